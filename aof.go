@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrLogCorrupt = errors.New("Append log is corrupt")
+
+// SyncPolicy tells how often the append log is flushed to disk with fsync,
+// that is how many of the last writes a crash of the machine may lose.
+type SyncPolicy int
+
+const (
+	SyncEverySecond SyncPolicy = iota // at most a second of writes
+	SyncAlways                        // none, at the price of an fsync per write
+	SyncNever                         // whatever the OS has not written yet
+)
+
+// WithAppendLog records every Set, Delete and Flush in the file at path and
+// replays it when the cache is created, so the items survive a restart or
+// crash. The replayed log is rewritten to hold only the items left, then
+// appended to, and rewritten again in the background whenever it doubled
+// in size since, once it holds at least 16 MiB. Sliding renewals and Pin
+// are not recorded. Values go through encoding/gob, so their concrete types
+// must be registered with gob.Register; writing a value gob can not encode
+// fails and leaves the cache unchanged. A log that can not be opened or
+// replayed is reported to the logger and the cache runs without it. After
+// failing to write to the file the log stops recording, and the next write
+// rewrites it, retried with a backoff of up to a minute while that fails.
+func WithAppendLog(path string, policy SyncPolicy) Option {
+	return func(c *Cache) {
+		c.aofPath = path
+		c.aofSync = policy
+	}
+}
+
+const (
+	logSet = iota
+	logDelete
+	logFlush
+)
+
+// logRecord is an operation as written to the append log. Expires is
+// absolute so replaying does not extend the lifetimes; zero if never.
+// Every record is a gob stream of its own behind its length, so one
+// that fails to encode can be left out without breaking the others.
+type logRecord struct {
+	Op       int
+	Key      string
+	Value    interface{}
+	Created  time.Time
+	Expires  time.Time
+	Priority Priority
+	Pinned   bool
+}
+
+// aofRewriteMinSize is the smallest log that is rewritten while running.
+const aofRewriteMinSize = 16 << 20
+
+// aofRetryMax is the longest wait to rewrite a failed log again.
+const aofRetryMax = time.Minute
+
+// appendLog is the open log, shared by the shards of a cache.
+type appendLog struct {
+	mu        sync.Mutex
+	f         *os.File
+	policy    SyncPolicy
+	size      int64 // of the file
+	rewriteAt int64 // size starting the next rewrite
+	rewriting bool
+	pending   []byte // records appended since the rewrite started
+	dirty     bool   // written since the last fsync
+	closed    bool
+	err       error
+	retryAt   time.Time     // earliest rewrite of a failed log
+	backoff   time.Duration // after the last rewrite that failed
+	cache     *Cache        // the parent of the shards, reports the errors
+}
+
+// openAppendLog replays the log into the cache and reopens it for writing.
+func (c *Cache) openAppendLog() {
+	if err := c.replayLog(); err != nil {
+		c.log(LogError, "append log replay failed", "path", c.aofPath, "err", err)
+		return
+	}
+	aof := &appendLog{policy: c.aofSync, cache: c}
+	if err := aof.rewrite(); err != nil {
+		c.log(LogError, "append log rewrite failed", "path", c.aofPath, "err", err)
+		return
+	}
+	c.aof = aof
+	for _, s := range c.shards {
+		s.aof = aof
+	}
+	if c.aofSync == SyncEverySecond {
+		go c.syncLogEvery(time.Second)
+	}
+}
+
+// replayLog applies the records of the log. A record cut short by a crash
+// ends the log like its end does.
+func (c *Cache) replayLog() error {
+	f, err := os.Open(c.aofPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	for {
+		var r logRecord
+		err := readRecord(br, &r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch r.Op {
+		case logSet:
+			saved := savedItem{Key: r.Key, Value: r.Value, Created: r.Created, Priority: r.Priority, Pinned: r.Pinned}
+			if !r.Expires.IsZero() {
+				saved.TTL = r.Expires.Sub(c.clock.Now())
+				if saved.TTL <= 0 && !r.Pinned {
+					c.Delete(r.Key)
+					continue
+				}
+				saved.TTL = max(saved.TTL, 1)
+			}
+			if err := c.restore(saved); err != nil {
+				return err
+			}
+		case logDelete:
+			c.Delete(r.Key)
+		case logFlush:
+			c.Flush()
+		}
+	}
+}
+
+// rewrite replaces the log with one holding the items of the cache and
+// the records appended meanwhile, and goes on appending to that one.
+// The cache is walked without the log locked, so writes go on.
+func (l *appendLog) rewrite() error {
+	c := l.cache
+	l.mu.Lock()
+	l.rewriting = true
+	l.mu.Unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(c.aofPath), filepath.Base(c.aofPath)+".*.tmp")
+	if err != nil {
+		l.rewriteDone(nil, 0, err)
+		return err
+	}
+	counted := &countingWriter{w: f}
+	bw := bufio.NewWriter(counted)
+	now := c.clock.Now()
+	err = c.eachSaved(func(saved savedItem) error {
+		r := logRecord{
+			Op:       logSet,
+			Key:      saved.Key,
+			Value:    saved.Value,
+			Created:  saved.Created,
+			Priority: saved.Priority,
+			Pinned:   saved.Pinned,
+		}
+		if saved.TTL > 0 {
+			r.Expires = now.Add(saved.TTL)
+		}
+		frame, err := encodeRecord(&r)
+		if err == nil {
+			_, err = bw.Write(frame)
+		}
+		return err
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	return l.rewriteDone(f, counted.n, err)
+}
+
+// rewriteDone completes the rewrite of the items to f, size bytes so far.
+func (l *appendLog) rewriteDone(f *os.File, size int64, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.pending
+	l.pending, l.rewriting = nil, false
+	if err == nil && l.closed {
+		err = ErrClosed
+	}
+	if err == nil {
+		_, err = f.Write(pending)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), l.cache.aofPath)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+		l.rewriteAt = max(2*l.size, aofRewriteMinSize)
+		l.backoff = min(max(2*l.backoff, time.Second), aofRetryMax)
+		l.retryAt = l.cache.clock.Now().Add(l.backoff)
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	// the new log holds everything, so one that failed can start over
+	l.f, l.err, l.dirty, l.backoff = f, nil, false, 0
+	l.size = size + int64(len(pending))
+	l.rewriteAt = max(2*l.size, aofRewriteMinSize)
+	return nil
+}
+
+// rewriteInBackground is rewrite started by a log grown to rewriteAt.
+func (l *appendLog) rewriteInBackground() {
+	if err := l.rewrite(); err != nil && err != ErrClosed {
+		l.cache.log(LogError, "append log rewrite failed", "path", l.cache.aofPath, "err", err)
+	}
+}
+
+// setRecord encodes the record of an item about to be stored over one
+// pinned or not, for append once it is.
+func (l *appendLog) setRecord(c *Cache, key string, item Item, pinned bool) ([]byte, error) {
+	if l == nil {
+		return nil, nil
+	}
+	r := logRecord{
+		Op:       logSet,
+		Key:      key,
+		Value:    item.Value,
+		Created:  item.Created,
+		Priority: item.Priority,
+		Pinned:   item.Pinned || pinned,
+	}
+	if item.Expiration > 0 {
+		r.Expires = c.wallTime(item.Expiration)
+	}
+	return encodeRecord(&r)
+}
+
+func (l *appendLog) delete(key string) {
+	if l == nil {
+		return
+	}
+	l.write(&logRecord{Op: logDelete, Key: key})
+}
+
+func (l *appendLog) flush() {
+	if l == nil {
+		return
+	}
+	l.write(&logRecord{Op: logFlush})
+}
+
+// write appends a record holding no value, which gob always encodes.
+func (l *appendLog) write(r *logRecord) {
+	frame, err := encodeRecord(r)
+	if err != nil {
+		l.mu.Lock()
+		l.fail(err)
+		l.mu.Unlock()
+		return
+	}
+	l.append(frame)
+}
+
+// append writes an encoded record. After the first failure the log stops
+// recording until a rewrite, so it is never replayed with a gap in the
+// middle; the records that follow start one once the backoff has passed.
+func (l *appendLog) append(frame []byte) {
+	if l == nil || frame == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if l.rewriting {
+		l.pending = append(l.pending, frame...)
+	}
+	if l.f == nil {
+		return
+	}
+	if l.err != nil {
+		if !l.rewriting && !l.cache.clock.Now().Before(l.retryAt) {
+			l.rewriting = true
+			go l.rewriteInBackground()
+		}
+		return
+	}
+	_, err := l.f.Write(frame)
+	if err == nil && l.policy == SyncAlways {
+		err = l.f.Sync()
+	}
+	if l.policy == SyncEverySecond {
+		l.dirty = true
+	}
+	l.size += int64(len(frame))
+	l.fail(err)
+	if err == nil && !l.rewriting && l.size >= l.rewriteAt {
+		l.rewriting = true
+		go l.rewriteInBackground()
+	}
+}
+
+// sync flushes the records written since the last call to disk.
+func (l *appendLog) sync() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty || l.f == nil {
+		return
+	}
+	l.dirty = false
+	l.fail(l.f.Sync())
+}
+
+func (l *appendLog) fail(err error) {
+	if err == nil {
+		return
+	}
+	l.err = err
+	l.cache.log(LogError, "append log write failed", "path", l.cache.aofPath, "err", err)
+}
+
+func (l *appendLog) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// syncLogEvery syncs the log until the cache is closed. Once the context of
+// NewWithContext is done it syncs a last time, later writes are synced by Close.
+func (c *Cache) syncLogEvery(interval time.Duration) {
+	for {
+		select {
+		case <-c.clock.After(interval):
+			c.aof.sync()
+		case <-c.stop:
+			return
+		case <-c.done:
+			c.aof.sync()
+			return
+		}
+	}
+}
+
+// encodeRecord returns the record as written to the log.
+func encodeRecord(r *logRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return nil, err
+	}
+	frame := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+buf.Len()), uint64(buf.Len()))
+	return append(frame, buf.Bytes()...), nil
+}
+
+// maxLogRecord bounds the length read for a record, so a corrupt one
+// is not taken for a huge allocation.
+const maxLogRecord = 1 << 30
+
+// readRecord reads the next record of the log, io.EOF at its end and
+// io.ErrUnexpectedEOF if it was cut short.
+func readRecord(br *bufio.Reader, r *logRecord) error {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return err
+	}
+	if n > maxLogRecord {
+		return ErrLogCorrupt
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(r)
+}
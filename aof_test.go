@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type unregisteredValue struct {
+	N int
+}
+
+func (l *appendLog) isRewriting() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rewriting
+}
+
+func TestAppendLogReplay(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncEverySecond, SyncNever} {
+		clock := newTestClock()
+		path := filepath.Join(t.TempDir(), "cache.aof")
+		c := New(0, 0, WithShards(2), WithAppendLog(path, policy), WithClock(clock))
+		c.Set("a", 1, NoExpiration)
+		c.Set("b", 2, time.Hour)
+		c.Set("short", 3, time.Second)
+		c.Set("deleted", 4, NoExpiration)
+		c.Delete("deleted")
+		c.Set("a", "one", NoExpiration)
+		clock.Advance(time.Minute)
+		c.Close()
+
+		c = New(0, 0, WithAppendLog(path, policy), WithClock(clock))
+		if n := c.Count(); n != 2 {
+			t.Fatalf("policy %d: count = %d, want 2: %v", policy, n, c.GetAll())
+		}
+		if v, _ := c.Get("a"); v != "one" {
+			t.Errorf("policy %d: a = %v, want the last value", policy, v)
+		}
+		if _, expiration, _ := c.GetWithExpiration("b"); expiration.Sub(clock.Now()) != 59*time.Minute {
+			t.Errorf("policy %d: b expires at %v", policy, expiration)
+		}
+		c.Close()
+	}
+}
+
+func TestAppendLogBadValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	c := New(0, 0, WithAppendLog(path, SyncNever))
+	if err := c.Set("a", 1, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("b", unregisteredValue{1}, NoExpiration); err == nil {
+		t.Error("stored a value the log can not encode")
+	}
+	if _, found := c.Get("b"); found {
+		t.Error("failed write changed the cache")
+	}
+	if err := c.Set("c", 2, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	c = New(0, 0, WithAppendLog(path, SyncNever))
+	defer c.Close()
+	if n := c.Count(); n != 2 {
+		t.Errorf("count = %d, want 2: %v", n, c.GetAll())
+	}
+}
+
+func TestAppendLogShardedFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	c := New(0, 0, WithShards(4), WithAppendLog(path, SyncNever))
+	for i := 0; i < 50; i++ {
+		c.Set(fmt.Sprint(i), i, NoExpiration)
+	}
+	c.Flush()
+	c.Set("after", 1, NoExpiration)
+	c.Close()
+
+	c = New(0, 0, WithShards(3), WithAppendLog(path, SyncNever))
+	defer c.Close()
+	if keys := c.Keys(); len(keys) != 1 || keys[0] != "after" {
+		t.Errorf("keys = %v, want only the one set after the flush", keys)
+	}
+}
+
+func TestAppendLogTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	c := New(0, 0, WithAppendLog(path, SyncAlways))
+	c.Set("a", 1, NoExpiration)
+	c.Close()
+
+	// a record cut short by a crash
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{40, 1, 2})
+	f.Close()
+
+	c = New(0, 0, WithAppendLog(path, SyncAlways))
+	defer c.Close()
+	if n := c.Count(); n != 1 {
+		t.Errorf("count = %d, want 1", n)
+	}
+}
+
+func TestAppendLogRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	c := New(0, 0, WithShards(4), WithAppendLog(path, SyncNever))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprint(g, "-", i%100)
+				if i%5 == 0 {
+					c.Delete(key)
+				} else {
+					c.Set(key, i, NoExpiration)
+				}
+				if i%200 == 0 {
+					// start a rewrite while the writes go on
+					c.aof.mu.Lock()
+					c.aof.rewriteAt = 0
+					c.aof.mu.Unlock()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	waitFor(t, "the rewrite", func() bool { return !c.aof.isRewriting() })
+	want := c.GetAll()
+	c.Close()
+	if tmp, _ := filepath.Glob(path + ".*.tmp"); len(tmp) != 0 {
+		t.Errorf("left behind %v", tmp)
+	}
+
+	c = New(0, 0, WithAppendLog(path, SyncNever))
+	defer c.Close()
+	got := c.GetAll()
+	if len(got) != len(want) {
+		t.Fatalf("replayed %d items, want %d", len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestAppendLogRetry(t *testing.T) {
+	clock := newTestClock()
+	dir := filepath.Join(t.TempDir(), "log")
+	os.Mkdir(dir, 0o755)
+	path := filepath.Join(dir, "cache.aof")
+	c := New(0, 0, WithAppendLog(path, SyncNever), WithClock(clock))
+	c.Set("a", 1, NoExpiration)
+
+	// fail the writes, and the rewrites by taking their directory away
+	c.aof.mu.Lock()
+	c.aof.f.Close()
+	c.aof.mu.Unlock()
+	os.RemoveAll(dir)
+	c.Set("b", 2, NoExpiration)
+	c.Set("c", 3, NoExpiration)
+	waitFor(t, "the failed rewrite", func() bool { return !c.aof.isRewriting() })
+	c.Set("d", 4, NoExpiration)
+	if c.aof.isRewriting() {
+		t.Error("rewrite retried before the backoff")
+	}
+
+	os.Mkdir(dir, 0o755)
+	clock.Advance(time.Second)
+	c.Set("e", 5, NoExpiration)
+	waitFor(t, "the rewrite", func() bool { return !c.aof.isRewriting() })
+	c.Set("f", 6, NoExpiration)
+	c.Close()
+
+	c = New(0, 0, WithAppendLog(path, SyncNever), WithClock(clock))
+	defer c.Close()
+	if n := c.Count(); n != 6 {
+		t.Errorf("count = %d, want 6: %v", n, c.GetAll())
+	}
+}
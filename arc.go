@@ -0,0 +1,98 @@
+package main
+
+// arcPolicy is the Adaptive Replacement Cache. t1 holds keys seen once and
+// t2 keys seen again; b1 and b2 remember keys recently evicted from each.
+// A new key found in a ghost list shows which side was evicted too eagerly,
+// and moves the target size p of t1 accordingly.
+type arcPolicy struct {
+	t1, t2, b1, b2 *lruPolicy
+	capacity       int
+	p              int
+}
+
+func newARCPolicy(capacity int) *arcPolicy {
+	return &arcPolicy{
+		t1:       newLRUPolicy(),
+		t2:       newLRUPolicy(),
+		b1:       newLRUPolicy(),
+		b2:       newLRUPolicy(),
+		capacity: capacity,
+	}
+}
+
+func (p *arcPolicy) OnSet(key string) {
+	if p.resident(key) {
+		p.OnGet(key)
+		return
+	}
+	switch {
+	case p.in(p.b1, key):
+		p.p = min(p.capacity, p.p+max(1, p.b2.ll.Len()/p.b1.ll.Len()))
+		p.b1.OnDelete(key)
+		p.t2.OnSet(key)
+	case p.in(p.b2, key):
+		p.p = max(0, p.p-max(1, p.b1.ll.Len()/p.b2.ll.Len()))
+		p.b2.OnDelete(key)
+		p.t2.OnSet(key)
+	default:
+		p.t1.OnSet(key)
+	}
+	p.trimGhosts()
+}
+
+func (p *arcPolicy) OnGet(key string) {
+	if p.in(p.t1, key) {
+		p.t1.OnDelete(key)
+		p.t2.OnSet(key)
+		return
+	}
+	p.t2.OnGet(key)
+}
+
+func (p *arcPolicy) OnDelete(key string) {
+	p.t1.OnDelete(key)
+	p.t2.OnDelete(key)
+}
+
+func (p *arcPolicy) Victim() (string, bool) {
+	n := p.t1.ll.Len()
+	if n > 0 && (n > p.p || p.t2.ll.Len() == 0) {
+		key, _ := p.t1.Victim()
+		p.b1.OnSet(key)
+		p.trimGhosts()
+		return key, true
+	}
+	key, ok := p.t2.Victim()
+	if !ok {
+		return "", false
+	}
+	p.b2.OnSet(key)
+	p.trimGhosts()
+	return key, true
+}
+
+// trimGhosts keeps |t1|+|b1| within the capacity and all four lists within twice it.
+func (p *arcPolicy) trimGhosts() {
+	for p.b1.ll.Len() > 0 && p.t1.ll.Len()+p.b1.ll.Len() > p.capacity {
+		p.b1.Victim()
+	}
+	for p.b2.ll.Len() > 0 && p.size() > 2*p.capacity {
+		p.b2.Victim()
+	}
+	for p.b1.ll.Len() > 0 && p.size() > 2*p.capacity {
+		p.b1.Victim()
+	}
+}
+
+func (p *arcPolicy) size() int {
+	return p.t1.ll.Len() + p.t2.ll.Len() + p.b1.ll.Len() + p.b2.ll.Len()
+}
+
+func (p *arcPolicy) resident(key string) bool {
+	return p.in(p.t1, key) || p.in(p.t2, key)
+}
+
+func (p *arcPolicy) in(l *lruPolicy, key string) bool {
+	_, found := l.elems[key]
+	return found
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSlabSize = 1 << 20
+	// the slabs are rewritten once more than 1/arenaGarbageRatio of them is dead
+	arenaGarbageRatio = 2
+)
+
+var ErrNotBytes = errors.New("Value is not a byte slice")
+
+// Codec turns values into bytes and back for ArenaCache.
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// GobCodec encodes values with encoding/gob. Concrete types stored
+// behind interface{} must be registered with gob.Register.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var value interface{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// RawCodec stores []byte values as they are; Unmarshal returns a copy.
+type RawCodec struct{}
+
+func (RawCodec) Marshal(value interface{}) ([]byte, error) {
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, ErrNotBytes
+	}
+	return data, nil
+}
+
+func (RawCodec) Unmarshal(data []byte) (interface{}, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// ArenaCache keeps serialized values in large byte slabs, indexed by offsets.
+// Neither the slabs nor the index hold pointers, so the Go GC does not have
+// to scan millions of entries. Every Get decodes a copy of the value.
+type ArenaCache struct {
+	sync.RWMutex
+	defaultExpiration time.Duration
+	codec             Codec
+	seed              maphash.Seed
+	slabSize          int
+	slabs             [][]byte
+	used              int              // bytes of the slabs written to
+	garbage           int              // bytes of them no longer referenced
+	index             map[uint64]int32 // key hash to the first entry in its chain
+	entries           []arenaEntry
+	free              []int32 // unused positions in entries
+	clock             Clock
+	epoch             time.Time
+	stop              chan struct{}
+	closed            bool
+}
+
+// ArenaOption configures an ArenaCache created by NewArena.
+type ArenaOption func(*ArenaCache)
+
+// WithArenaClock is WithClock for an ArenaCache.
+func WithArenaClock(clock Clock) ArenaOption {
+	return func(c *ArenaCache) {
+		c.clock = clock
+	}
+}
+
+type arenaEntry struct {
+	hash       uint64
+	slab       int32 // -1 if the entry is free
+	offset     int32 // of the key, the value follows it
+	keyLen     int32
+	valueLen   int32
+	next       int32 // next entry with the same hash, -1 at the end
+	expiration int64 // nanoseconds since the cache was created, 0 if it never expires
+}
+
+// NewArena returns an ArenaCache storing values encoded by codec in slabs
+// of slabSize bytes, or 1 MiB if it is not positive. Expired values are
+// deleted every cleanupInterval if it is positive.
+func NewArena(defaultExpiration, cleanupInterval time.Duration, codec Codec, slabSize int, opts ...ArenaOption) *ArenaCache {
+	if slabSize <= 0 {
+		slabSize = defaultSlabSize
+	}
+	c := &ArenaCache{
+		defaultExpiration: defaultExpiration,
+		codec:             codec,
+		seed:              maphash.MakeSeed(),
+		slabSize:          slabSize,
+		index:             make(map[uint64]int32),
+		clock:             systemClock{},
+		stop:              make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.epoch = c.clock.Now()
+	if cleanupInterval > 0 {
+		go c.gc(cleanupInterval)
+	}
+	return c
+}
+
+// Set encodes the value and copies it into the slabs.
+func (c *ArenaCache) Set(key string, value interface{}, duration time.Duration) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	expiration := c.expiration(duration)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	hash := maphash.String(c.seed, key)
+	if i, _ := c.find(hash, key); i >= 0 {
+		c.unlink(hash, i)
+	}
+	c.insert(hash, key, data, expiration)
+	c.compactIfGarbage()
+	return nil
+}
+
+// Get decodes a copy of the value, ErrKeyNotFound if there is none.
+func (c *ArenaCache) Get(key string) (interface{}, error) {
+	c.RLock()
+	i, _ := c.find(maphash.String(c.seed, key), key)
+	if i < 0 || c.entries[i].expired(c.now()) {
+		c.RUnlock()
+		return nil, ErrKeyNotFound
+	}
+	data := append([]byte(nil), c.value(c.entries[i])...)
+	c.RUnlock()
+
+	return c.codec.Unmarshal(data)
+}
+
+// GetBytes returns a copy of the encoded value without decoding it.
+func (c *ArenaCache) GetBytes(key string) ([]byte, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	i, _ := c.find(maphash.String(c.seed, key), key)
+	if i < 0 || c.entries[i].expired(c.now()) {
+		return nil, false
+	}
+	return append([]byte(nil), c.value(c.entries[i])...), true
+}
+
+func (c *ArenaCache) Delete(key string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	hash := maphash.String(c.seed, key)
+	i, _ := c.find(hash, key)
+	if i < 0 {
+		return ErrKeyNotFound
+	}
+	c.unlink(hash, i)
+	c.compactIfGarbage()
+	return nil
+}
+
+// Count returns the number of stored values, including expired ones
+// the GC has not deleted yet.
+func (c *ArenaCache) Count() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.entries) - len(c.free)
+}
+
+// SlabBytes returns the bytes allocated for slabs.
+func (c *ArenaCache) SlabBytes() int {
+	c.RLock()
+	defer c.RUnlock()
+
+	n := 0
+	for _, slab := range c.slabs {
+		n += cap(slab)
+	}
+	return n
+}
+
+func (c *ArenaCache) Flush() {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.closed {
+		c.reset()
+	}
+}
+
+// Close stops the GC goroutine and releases the slabs.
+func (c *ArenaCache) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	close(c.stop)
+	c.reset()
+	return nil
+}
+
+func (c *ArenaCache) reset() {
+	c.slabs = nil
+	c.used, c.garbage = 0, 0
+	c.index = make(map[uint64]int32)
+	c.entries = nil
+	c.free = nil
+}
+
+// find returns the position of the key's entry and of the one before it
+// in the chain, -1 if there is none.
+func (c *ArenaCache) find(hash uint64, key string) (i, prev int32) {
+	i, found := c.index[hash]
+	if !found {
+		return -1, -1
+	}
+	for prev = -1; i >= 0; prev, i = i, c.entries[i].next {
+		if string(c.key(c.entries[i])) == key {
+			return i, prev
+		}
+	}
+	return -1, -1
+}
+
+func (c *ArenaCache) insert(hash uint64, key string, data []byte, expiration int64) {
+	slab, offset := c.alloc(len(key) + len(data))
+	copy(c.slabs[slab][offset:], key)
+	copy(c.slabs[slab][offset+len(key):], data)
+
+	e := arenaEntry{
+		hash:       hash,
+		slab:       int32(slab),
+		offset:     int32(offset),
+		keyLen:     int32(len(key)),
+		valueLen:   int32(len(data)),
+		next:       -1,
+		expiration: expiration,
+	}
+	if head, found := c.index[hash]; found {
+		e.next = head
+	}
+	var i int32
+	if n := len(c.free); n > 0 {
+		i = c.free[n-1]
+		c.free = c.free[:n-1]
+		c.entries[i] = e
+	} else {
+		i = int32(len(c.entries))
+		c.entries = append(c.entries, e)
+	}
+	c.index[hash] = i
+}
+
+// unlink removes the entry at i from its chain; its bytes become garbage.
+func (c *ArenaCache) unlink(hash uint64, i int32) {
+	_, prev := c.find(hash, string(c.key(c.entries[i])))
+	e := c.entries[i]
+	switch {
+	case prev >= 0:
+		c.entries[prev].next = e.next
+	case e.next >= 0:
+		c.index[hash] = e.next
+	default:
+		delete(c.index, hash)
+	}
+	c.garbage += int(e.keyLen + e.valueLen)
+	c.entries[i] = arenaEntry{slab: -1, next: -1}
+	c.free = append(c.free, i)
+}
+
+// alloc reserves n bytes at the end of the last slab, starting a new one
+// if they do not fit. Values larger than a slab get a slab of their own.
+func (c *ArenaCache) alloc(n int) (slab, offset int) {
+	last := len(c.slabs) - 1
+	if last < 0 || len(c.slabs[last])+n > cap(c.slabs[last]) {
+		c.slabs = append(c.slabs, make([]byte, 0, max(c.slabSize, n)))
+		last++
+	}
+	offset = len(c.slabs[last])
+	c.slabs[last] = c.slabs[last][:offset+n]
+	c.used += n
+	return last, offset
+}
+
+func (c *ArenaCache) key(e arenaEntry) []byte {
+	return c.slabs[e.slab][e.offset : e.offset+e.keyLen]
+}
+
+func (c *ArenaCache) value(e arenaEntry) []byte {
+	start := e.offset + e.keyLen
+	return c.slabs[e.slab][start : start+e.valueLen]
+}
+
+// compactIfGarbage copies the live entries into new slabs once enough
+// of the old ones is dead, so deleted values do not keep their memory.
+func (c *ArenaCache) compactIfGarbage() {
+	if c.used < c.slabSize || c.garbage*arenaGarbageRatio < c.used {
+		return
+	}
+	slabs := c.slabs
+	c.slabs = nil
+	c.used, c.garbage = 0, 0
+	for i := range c.entries {
+		e := &c.entries[i]
+		if e.slab < 0 {
+			continue
+		}
+		n := int(e.keyLen + e.valueLen)
+		slab, offset := c.alloc(n)
+		copy(c.slabs[slab][offset:], slabs[e.slab][e.offset:int(e.offset)+n])
+		e.slab, e.offset = int32(slab), int32(offset)
+	}
+}
+
+func (c *ArenaCache) gc(interval time.Duration) {
+	for {
+		select {
+		case <-c.clock.After(interval):
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *ArenaCache) deleteExpired() {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	for i := range c.entries {
+		e := c.entries[i]
+		if e.expired(now) {
+			c.unlink(e.hash, int32(i))
+		}
+	}
+	c.compactIfGarbage()
+}
+
+func (c *ArenaCache) expiration(duration time.Duration) int64 {
+	if duration == DefaultExpiration {
+		duration = c.defaultExpiration
+	}
+	if duration > 0 {
+		return c.now() + int64(duration)
+	}
+	return 0
+}
+
+func (c *ArenaCache) now() int64 {
+	return int64(c.clock.Now().Sub(c.epoch))
+}
+
+func (e arenaEntry) expired(now int64) bool {
+	return e.expiration > 0 && now > e.expiration
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArenaClock(t *testing.T) {
+	clock := newTestClock()
+	c := NewArena(time.Minute, time.Hour, RawCodec{}, 0, WithArenaClock(clock))
+	defer c.Close()
+	c.Set("a", []byte("a"), DefaultExpiration)
+	c.Set("b", []byte("b"), NoExpiration)
+	clock.Advance(2 * time.Minute)
+	if _, err := c.Get("a"); err != ErrKeyNotFound {
+		t.Errorf("expired value: %v", err)
+	}
+	if v, err := c.Get("b"); err != nil || string(v.([]byte)) != "b" {
+		t.Errorf("b = %v, %v", v, err)
+	}
+	waitFor(t, "the GC timer", func() bool { return clock.timers() > 0 })
+	clock.Advance(time.Hour)
+	waitFor(t, "the GC", func() bool { return c.Count() == 1 })
+}
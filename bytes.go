@@ -0,0 +1,203 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// BytesCache is a cache of []byte values, for serialized data. Values are
+// kept in a typed map instead of boxed in an interface{}, so Set does not
+// allocate beyond the map entry itself.
+type BytesCache struct {
+	sync.RWMutex
+	defaultExpiration time.Duration
+	items             map[string]bytesItem
+	clock             Clock
+	epoch             time.Time
+	stop              chan struct{}
+	closed            bool
+}
+
+// BytesOption configures a BytesCache created by NewBytes.
+type BytesOption func(*BytesCache)
+
+// WithBytesClock is WithClock for a BytesCache.
+func WithBytesClock(clock Clock) BytesOption {
+	return func(c *BytesCache) {
+		c.clock = clock
+	}
+}
+
+type bytesItem struct {
+	value      []byte
+	expiration int64 // nanoseconds since the cache was created, 0 if it never expires
+}
+
+// NewBytes returns a BytesCache. Expired values are deleted every
+// cleanupInterval, or only found absent by reads if it is not positive.
+func NewBytes(defaultExpiration, cleanupInterval time.Duration, opts ...BytesOption) *BytesCache {
+	c := &BytesCache{
+		defaultExpiration: defaultExpiration,
+		items:             make(map[string]bytesItem),
+		clock:             systemClock{},
+		stop:              make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.epoch = c.clock.Now()
+	if cleanupInterval > 0 {
+		go c.gc(cleanupInterval)
+	}
+	return c
+}
+
+// Set stores value for duration, DefaultExpiration or NoExpiration. The
+// slice is kept as is: the caller must not modify it afterwards.
+func (c *BytesCache) Set(key string, value []byte, duration time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.items[key] = bytesItem{value: value, expiration: c.expiration(duration)}
+	return nil
+}
+
+// SetCopy is Set with a copy of value, so the caller may reuse its buffer.
+func (c *BytesCache) SetCopy(key string, value []byte, duration time.Duration) error {
+	return c.Set(key, append([]byte(nil), value...), duration)
+}
+
+// Get returns a copy of the value.
+func (c *BytesCache) Get(key string) ([]byte, bool) {
+	value, found := c.GetNoCopy(key)
+	if !found {
+		return nil, false
+	}
+	return append([]byte(nil), value...), true
+}
+
+// GetNoCopy returns the stored slice itself without copying it. It must
+// be treated as read-only, as it is shared with every other reader.
+func (c *BytesCache) GetNoCopy(key string) ([]byte, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return nil, false
+	}
+	return item.value, true
+}
+
+// AppendTo appends the value to dst and returns the extended slice, so
+// the caller can copy into a buffer of its own.
+func (c *BytesCache) AppendTo(dst []byte, key string) ([]byte, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return dst, false
+	}
+	return append(dst, item.value...), true
+}
+
+// View calls fn with the stored slice while holding the read lock. The
+// slice must not be modified nor retained after fn returns.
+func (c *BytesCache) View(key string, fn func(value []byte)) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return false
+	}
+	fn(item.value)
+	return true
+}
+
+func (c *BytesCache) Delete(key string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, found := c.items[key]; !found {
+		return ErrKeyNotFound
+	}
+	delete(c.items, key)
+	return nil
+}
+
+// Count returns the number of stored values, including expired ones
+// the GC has not deleted yet.
+func (c *BytesCache) Count() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.items)
+}
+
+func (c *BytesCache) Flush() {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.closed {
+		c.items = make(map[string]bytesItem)
+	}
+}
+
+// Close stops the GC goroutine and releases all values.
+func (c *BytesCache) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	close(c.stop)
+	c.items = nil
+	return nil
+}
+
+func (c *BytesCache) gc(interval time.Duration) {
+	for {
+		select {
+		case <-c.clock.After(interval):
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *BytesCache) deleteExpired() {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	for key, item := range c.items {
+		if item.expired(now) {
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *BytesCache) expiration(duration time.Duration) int64 {
+	if duration == DefaultExpiration {
+		duration = c.defaultExpiration
+	}
+	if duration > 0 {
+		return c.now() + int64(duration)
+	}
+	return 0
+}
+
+func (c *BytesCache) now() int64 {
+	return int64(c.clock.Now().Sub(c.epoch))
+}
+
+func (i bytesItem) expired(now int64) bool {
+	return i.expiration > 0 && now > i.expiration
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBytesClock(t *testing.T) {
+	clock := newTestClock()
+	c := NewBytes(time.Minute, time.Hour, WithBytesClock(clock))
+	defer c.Close()
+	c.Set("a", []byte("a"), DefaultExpiration)
+	c.Set("b", []byte("b"), NoExpiration)
+	clock.Advance(2 * time.Minute)
+	if _, found := c.Get("a"); found {
+		t.Error("not expired")
+	}
+	waitFor(t, "the GC timer", func() bool { return clock.timers() > 0 })
+	clock.Advance(time.Hour)
+	waitFor(t, "the GC", func() bool { return c.Count() == 1 })
+}
+
+func TestBytesClosed(t *testing.T) {
+	c := NewBytes(0, 0)
+	c.Close()
+	if err := c.Set("a", []byte("a"), NoExpiration); err != ErrClosed {
+		t.Errorf("Set after Close: %v", err)
+	}
+	if err := c.SetCopy("a", []byte("a"), NoExpiration); err != ErrClosed {
+		t.Errorf("SetCopy after Close: %v", err)
+	}
+}
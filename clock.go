@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for a cache. Tests can pass their own
+// with WithClock and move time forward instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock replaces the system clock used for all expirations and the GC.
+func WithClock(clock Clock) Option {
+	return func(c *Cache) {
+		c.clock = clock
+	}
+}
+
+// WithCoarseClock makes expiration checks read a time refreshed every
+// resolution by a background goroutine instead of asking the clock on every
+// operation. Items may live up to resolution longer or shorter than set.
+func WithCoarseClock(resolution time.Duration) Option {
+	return func(c *Cache) {
+		c.coarseResolution = resolution
+	}
+}
+
+// startCoarseClock starts refreshing c.coarse until the cache is closed.
+// It keeps running when the context of NewWithContext is done, as reads
+// still need the time to tell expired items.
+func (c *Cache) startCoarseClock() {
+	c.coarse = new(atomic.Int64)
+	c.coarse.Store(int64(c.clock.Now().Sub(c.epoch)))
+	go func() {
+		for {
+			select {
+			case <-c.clock.After(c.coarseResolution):
+				c.coarse.Store(int64(c.clock.Now().Sub(c.epoch)))
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
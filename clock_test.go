@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a Clock that only moves when the test advances it.
+type testClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+type clockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, clockWaiter{c.now.Add(d), ch})
+	return ch
+}
+
+// Advance moves the time forward, firing the timers that are due.
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiting
+}
+
+// timers is how many After channels have not fired yet.
+func (c *testClock) timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// waitFor polls cond until it holds, for results of background goroutines
+// woken by Advance.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClockExpiration(t *testing.T) {
+	clock := newTestClock()
+	c := New(0, 0, WithClock(clock))
+	c.Set("a", 1, time.Minute)
+	clock.Advance(59 * time.Second)
+	if _, found := c.Get("a"); !found {
+		t.Fatal("expired early")
+	}
+	clock.Advance(2 * time.Second)
+	if _, found := c.Get("a"); found {
+		t.Fatal("not expired")
+	}
+}
+
+func TestClockGC(t *testing.T) {
+	clock := newTestClock()
+	c := New(0, time.Minute, WithClock(clock))
+	defer c.Close()
+	c.Set("a", 1, time.Second)
+	c.Set("b", 2, NoExpiration)
+	waitFor(t, "the GC timer", func() bool { return clock.timers() > 0 })
+	clock.Advance(time.Minute)
+	waitFor(t, "the GC", func() bool { return c.Count() == 1 })
+}
@@ -0,0 +1,112 @@
+package main
+
+import "reflect"
+
+// WithCopyOnRead makes every read return a copy made by clone, so callers
+// modifying a returned map or slice do not change what the others read.
+// A nil clone uses DeepCopy. Values passed to Set are still stored as is.
+func WithCopyOnRead(clone func(value interface{}) interface{}) Option {
+	return func(c *Cache) {
+		if clone == nil {
+			clone = DeepCopy
+		}
+		c.cloner = clone
+	}
+}
+
+// CodecCloner returns a WithCopyOnRead clone function copying values by
+// encoding and decoding them, for types DeepCopy does not handle. Values
+// the codec fails on are returned as is.
+func CodecCloner(codec Codec) func(value interface{}) interface{} {
+	return func(value interface{}) interface{} {
+		data, err := codec.Marshal(value)
+		if err != nil {
+			return value
+		}
+		clone, err := codec.Unmarshal(data)
+		if err != nil {
+			return value
+		}
+		return clone
+	}
+}
+
+// read is what readers get of a stored value.
+func (c *Cache) read(value interface{}) interface{} {
+	if c.cloner == nil || value == nil {
+		return value
+	}
+	return c.cloner(value)
+}
+
+// DeepCopy copies maps, slices, arrays, pointers and the exported fields of
+// structs recursively. Unexported fields, channels and functions are shared
+// with the original. Values referenced more than once stay shared in the copy.
+func DeepCopy(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	seen := make(map[uintptr]reflect.Value)
+	return deepCopy(reflect.ValueOf(value), seen).Interface()
+}
+
+func deepCopy(v reflect.Value, seen map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		if c, found := seen[v.Pointer()]; found {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		seen[v.Pointer()] = c
+		c.Elem().Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		if c, found := seen[v.Pointer()]; found {
+			return c
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		seen[v.Pointer()] = c
+		for it := v.MapRange(); it.Next(); {
+			c.SetMapIndex(deepCopy(it.Key(), seen), deepCopy(it.Value(), seen))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i), seen))
+			}
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), seen))
+		return c
+	}
+	return v
+}
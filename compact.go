@@ -0,0 +1,113 @@
+package main
+
+// Go maps and slices never give back the memory of deleted entries. After
+// the GC finds the cache shrunk to less than 1/compactRatio of its peak,
+// it rebuilds them at their current size.
+const (
+	compactRatio    = 4
+	compactMinItems = 1024
+)
+
+// WithInitialCapacity sizes the storage for n items up front, so warming the
+// cache with a known number of items does not grow the map step by step.
+// Compaction does not shrink the storage below it.
+func WithInitialCapacity(n int) Option {
+	return func(c *Cache) {
+		c.initialCapacity = n
+	}
+}
+
+// Compact rebuilds the internal maps and slices to reclaim the memory held
+// for items removed since the cache was at its largest.
+func (c *Cache) Compact() {
+	if c.shards != nil {
+		for _, s := range c.shards {
+			s.Compact()
+		}
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.compact()
+}
+
+// compactIfSparse compacts once the keys slice, the size of which follows
+// the peak number of items, is mostly unused.
+func (c *Cache) compactIfSparse() {
+	if c.shards != nil {
+		for _, s := range c.shards {
+			s.compactIfSparse()
+		}
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	peak := cap(c.keys)
+	if peak >= compactMinItems && peak > c.initialCapacity && len(c.keys)*compactRatio < peak {
+		c.compact()
+	}
+}
+
+// compact is Compact for callers holding the write lock.
+func (c *Cache) compact() {
+	if c.closed {
+		return
+	}
+	c.items = compactMap(c.items, c.initialCapacity)
+	c.keys = append(make([]string, 0, max(len(c.keys), c.initialCapacity)), c.keys...)
+	c.expiries = append(expiryHeap(nil), c.expiries...)
+
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	compactPolicies(&c.policies)
+	for _, ns := range c.namespaces {
+		compactPolicies(&ns.policies)
+	}
+}
+
+func compactPolicies(policies *[numPriorities]EvictionPolicy) {
+	for _, p := range policies {
+		if p, ok := p.(compacter); ok {
+			p.compact()
+		}
+	}
+}
+
+// compacter is implemented by the built-in policies.
+type compacter interface {
+	compact()
+}
+
+// compactMap copies m into a new map with room for at least size entries.
+func compactMap[K comparable, V any](m map[K]V, size int) map[K]V {
+	compacted := make(map[K]V, max(len(m), size))
+	for k, v := range m {
+		compacted[k] = v
+	}
+	return compacted
+}
+
+func (p *lruPolicy) compact() {
+	p.elems = compactMap(p.elems, 0)
+}
+
+func (p *randomPolicy) compact() {
+	p.keys = append([]string(nil), p.keys...)
+	p.index = compactMap(p.index, 0)
+}
+
+func (p *lfuPolicy) compact() {
+	p.entries = compactMap(p.entries, 0)
+}
+
+func (p *arcPolicy) compact() {
+	for _, l := range []*lruPolicy{p.t1, p.t2, p.b1, p.b2} {
+		l.compact()
+	}
+}
+
+func (p *slruPolicy) compact() {
+	p.probation.compact()
+	p.protected.compact()
+}
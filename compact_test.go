@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCompactNamespacePolicies(t *testing.T) {
+	c := New(0, 0, WithNamespaceQuota("a", 10, 0))
+	for i := 0; i < 2000; i++ {
+		c.Set(fmt.Sprint("a:", i), i, NoExpiration)
+	}
+	p, ok := c.namespaces["a"].policies[PriorityNormal.class()].(*lruPolicy)
+	if !ok {
+		t.Fatal("namespace has no LRU policy")
+	}
+	before := reflect.ValueOf(p.elems).UnsafePointer()
+	c.Compact()
+	if reflect.ValueOf(p.elems).UnsafePointer() == before {
+		t.Error("namespace policy not compacted")
+	}
+	c.Set("a:new", 1, NoExpiration)
+	if n := c.Count(); n != 10 || !c.Exists("a:new") {
+		t.Errorf("count = %d after compacting, want 10 with the new key", n)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// debugTopKeys is how many hot keys the debug page lists,
+// debugForecastMinutes how far ahead it forecasts expirations.
+const (
+	debugTopKeys         = 20
+	debugForecastMinutes = 10
+)
+
+// DebugHandler serves the live state of the cache as JSON, in the manner of
+// net/http/pprof, to be mounted under a path such as /debug/memcache/.
+// With ?key=name it shows the metadata of that item instead.
+func (c *Cache) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var page interface{}
+		if key := r.URL.Query().Get("key"); key != "" {
+			info, found := c.GetItem(key)
+			if !found {
+				http.Error(w, ErrKeyNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			page = info
+		} else {
+			page = c.debugPage()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(page)
+	})
+}
+
+type debugPage struct {
+	Stats      Stats
+	HitRatio   float64
+	Capacity   CapacityStats
+	Evictions  map[string]uint64
+	TopKeys    []HotKey                  `json:",omitempty"`
+	Namespaces map[string]NamespaceStats `json:",omitempty"`
+	Latencies  map[string]debugLatency   `json:",omitempty"`
+	GCCycles   uint64
+	LastGC     string
+	TTL        debugTTL
+}
+
+type debugTTL struct {
+	Buckets     map[string]uint64 // items by the bound of their remaining lifetime
+	Persistent  int
+	NextMinutes []int // expiring in each of the next minutes
+}
+
+type debugLatency struct {
+	Count         uint64
+	P50, P99, Max string
+}
+
+func (c *Cache) debugPage() debugPage {
+	cycles, last := c.gcCycleStats()
+	page := debugPage{
+		Stats:      c.Stats(),
+		Capacity:   c.CapacityStats(),
+		Evictions:  make(map[string]uint64),
+		TopKeys:    c.TopKeys(debugTopKeys),
+		Namespaces: c.NamespaceStats(),
+		GCCycles:   cycles,
+		LastGC:     last.String(),
+	}
+	page.HitRatio = page.Stats.HitRatio()
+	ttl, persistent := c.TTLDistribution()
+	page.TTL = debugTTL{
+		Buckets:     make(map[string]uint64, len(ttl.Counts)),
+		Persistent:  persistent,
+		NextMinutes: c.ExpiryForecast(time.Minute, debugForecastMinutes),
+	}
+	for i, n := range ttl.Counts {
+		bound := "+Inf"
+		if i < len(ttl.Bounds) {
+			bound = ttl.Bounds[i].String()
+		}
+		page.TTL.Buckets[bound] = n
+	}
+	for reason, n := range page.Capacity.Evictions {
+		page.Evictions[reason.String()] = n
+	}
+	page.Capacity.Evictions = nil
+	if latencies := c.Latencies(); latencies != nil {
+		page.Latencies = make(map[string]debugLatency, len(latencies))
+		for op, h := range latencies {
+			page.Latencies[op] = debugLatency{
+				Count: h.Count,
+				P50:   h.Quantile(0.5).String(),
+				P99:   h.Quantile(0.99).String(),
+				Max:   h.Quantile(1).String(),
+			}
+		}
+	}
+	return page
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// WithEventLog keeps the last n writes and removals in memory for
+// RecentEvents, to find out what happened to a key.
+func WithEventLog(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.eventLog = &eventRing{events: make([]CacheEvent, 0, n)}
+		}
+	}
+}
+
+// CacheEvent is a write or removal recorded by WithEventLog. Op is "set",
+// "delete", "evict", "expire", "replace" or "flush", the last without a key.
+type CacheEvent struct {
+	Time time.Time
+	Op   string
+	Key  string
+}
+
+type eventRing struct {
+	events []CacheEvent
+	next   int // where the next event goes once the ring is full
+}
+
+func (r *eventRing) add(e CacheEvent) {
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, e)
+		return
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// ordered returns a copy of the events, the oldest first.
+func (r *eventRing) ordered() []CacheEvent {
+	events := make([]CacheEvent, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	return append(events, r.events[:r.next]...)
+}
+
+// RecentEvents returns the events recorded by WithEventLog, the oldest
+// first. Sharded caches keep the last n events of every shard.
+func (c *Cache) RecentEvents() []CacheEvent {
+	if c.shards != nil {
+		var events []CacheEvent
+		for _, s := range c.shards {
+			events = append(events, s.RecentEvents()...)
+		}
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Time.Before(events[j].Time)
+		})
+		return events
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.eventLog == nil {
+		return nil
+	}
+	return c.eventLog.ordered()
+}
+
+// logEvent records an event. The caller holds the write lock.
+func (c *Cache) logEvent(op, key string) {
+	if c.eventLog != nil {
+		c.eventLog.add(CacheEvent{Time: c.clock.Now(), Op: op, Key: key})
+	}
+}
+
+// eventOp is the event logged for items removed for reason.
+func eventOp(reason EvictionReason) string {
+	switch reason {
+	case ReasonExpired:
+		return "expire"
+	case ReasonCapacity:
+		return "evict"
+	case ReasonDeleted:
+		return "delete"
+	case ReasonReplaced:
+		return "replace"
+	}
+	return "flush"
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"container/list"
+	"math/rand"
+)
+
+// WithMaxItems bounds the number of items. Setting a new key beyond the
+// limit evicts items chosen by the policy, LRU unless WithPolicy says
+// otherwise. Pinned items are never evicted, but they count towards limits.
+func WithMaxItems(n int) Option {
+	return func(c *Cache) {
+		c.maxItems = n
+	}
+}
+
+// WithLowWatermark makes eviction go on once a limit is hit, until the
+// cache is down to the fraction of its limits: with 0.9 a full cache of
+// 1000 items evicts 100 at once rather than one per new key.
+func WithLowWatermark(fraction float64) Option {
+	return func(c *Cache) {
+		c.lowWatermark = fraction
+	}
+}
+
+// Policy selects which items a bounded cache evicts first.
+type Policy int
+
+const (
+	PolicyLRU     Policy = iota // least recently used
+	PolicyLFU                   // least frequently used, LRU among equals
+	PolicyTinyLFU               // LRU that may refuse rarely used new keys when full
+	PolicyARC                   // adaptive replacement, balances recency and frequency
+	PolicyFIFO                  // oldest insertion first, reads are not tracked
+	PolicyRandom                // uniformly random, reads are not tracked
+	PolicySLRU                  // segmented LRU, keys read again are protected
+)
+
+// WithPolicy sets the eviction policy used with WithMaxItems.
+func WithPolicy(p Policy) Option {
+	return func(c *Cache) {
+		c.newPolicy = func(capacity int) EvictionPolicy {
+			return newPolicy(p, capacity)
+		}
+	}
+}
+
+// WithEvictionPolicy makes a bounded cache use a custom policy. newPolicy is
+// called with the WithMaxItems limit whenever the cache needs a fresh one.
+func WithEvictionPolicy(newPolicy func(capacity int) EvictionPolicy) Option {
+	return func(c *Cache) {
+		c.newPolicy = newPolicy
+	}
+}
+
+// EvictionPolicy decides which items a bounded cache evicts. It only sees the
+// keys of unpinned items, and the cache serializes all calls to it.
+type EvictionPolicy interface {
+	// OnSet is called when a key is stored, whether it is new or replaced.
+	OnSet(key string)
+	// OnGet is called on every read, hit or miss. Unknown keys must be ignored.
+	OnGet(key string)
+	// OnDelete is called when a key is removed other than by eviction.
+	OnDelete(key string)
+	// Victim picks the next key to evict and forgets it. The cache drops the
+	// item right after. It returns false if the policy tracks no keys.
+	Victim() (string, bool)
+}
+
+// Admitter is implemented by policies that may refuse a new key when the
+// cache is full. Refused keys are not stored.
+type Admitter interface {
+	Admit(key string) bool
+}
+
+// NewLRUPolicy and NewLFUPolicy return the built-in policies, for example
+// to wrap them in a custom one.
+func NewLRUPolicy(int) EvictionPolicy {
+	return newLRUPolicy()
+}
+
+func NewLFUPolicy(int) EvictionPolicy {
+	return newLFUPolicy()
+}
+
+func newPolicy(p Policy, capacity int) EvictionPolicy {
+	switch p {
+	case PolicyLFU:
+		return newLFUPolicy()
+	case PolicyTinyLFU:
+		return newTinyLFUPolicy(capacity)
+	case PolicyARC:
+		return newARCPolicy(capacity)
+	case PolicyFIFO:
+		return fifoPolicy{newLRUPolicy()}
+	case PolicyRandom:
+		return newRandomPolicy()
+	case PolicySLRU:
+		return newSLRUPolicy(capacity)
+	default:
+		return newLRUPolicy()
+	}
+}
+
+// lruPolicy orders keys from the most to the least recently used.
+type lruPolicy struct {
+	ll    *list.List
+	elems map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) OnSet(key string) {
+	if e, found := p.elems[key]; found {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.elems[key] = p.ll.PushFront(key)
+}
+
+func (p *lruPolicy) OnGet(key string) {
+	if e, found := p.elems[key]; found {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) OnDelete(key string) {
+	if e, found := p.elems[key]; found {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+// oldest is the key victim would return, without forgetting it.
+func (p *lruPolicy) oldest() (string, bool) {
+	if e := p.ll.Back(); e != nil {
+		return e.Value.(string), true
+	}
+	return "", false
+}
+
+func (p *lruPolicy) Victim() (string, bool) {
+	e := p.ll.Back()
+	if e == nil {
+		return "", false
+	}
+	key := e.Value.(string)
+	p.ll.Remove(e)
+	delete(p.elems, key)
+	return key, true
+}
+
+// fifoPolicy is an LRU list that is never reordered.
+type fifoPolicy struct {
+	*lruPolicy
+}
+
+func (p fifoPolicy) OnSet(key string) {
+	if _, found := p.elems[key]; !found {
+		p.lruPolicy.OnSet(key)
+	}
+}
+
+func (p fifoPolicy) OnGet(string) {}
+
+// randomPolicy keeps the keys in a slice to pick victims in O(1).
+type randomPolicy struct {
+	keys  []string
+	index map[string]int
+}
+
+func newRandomPolicy() *randomPolicy {
+	return &randomPolicy{index: make(map[string]int)}
+}
+
+func (p *randomPolicy) OnSet(key string) {
+	if _, found := p.index[key]; !found {
+		p.index[key] = len(p.keys)
+		p.keys = append(p.keys, key)
+	}
+}
+
+func (p *randomPolicy) OnGet(string) {}
+
+func (p *randomPolicy) OnDelete(key string) {
+	i, found := p.index[key]
+	if !found {
+		return
+	}
+	last := len(p.keys) - 1
+	p.keys[i] = p.keys[last]
+	p.index[p.keys[i]] = i
+	p.keys = p.keys[:last]
+	delete(p.index, key)
+}
+
+func (p *randomPolicy) Victim() (string, bool) {
+	if len(p.keys) == 0 {
+		return "", false
+	}
+	key := p.keys[rand.Intn(len(p.keys))]
+	p.OnDelete(key)
+	return key, true
+}
+
+// defaultPolicyCapacity sizes the policies of caches bounded only by memory.
+const defaultPolicyCapacity = 1 << 16
+
+func (c *Cache) bounded() bool {
+	return c.maxItems > 0 || c.maxMemory > 0 || c.pressureLimit > 0 || len(c.namespaces) > 0
+}
+
+// overLimit reports whether n more items taking bytes would not fit.
+func (c *Cache) overLimit(n int, bytes int64) bool {
+	return (c.maxItems > 0 && len(c.items)+n > c.maxItems) ||
+		(c.maxMemory > 0 && c.usedBytes.Load()+bytes > c.maxMemory)
+}
+
+func (c *Cache) overWatermark(n int, bytes int64) bool {
+	if c.lowWatermark <= 0 {
+		return c.overLimit(n, bytes)
+	}
+	items := int(float64(c.maxItems) * c.lowWatermark)
+	memory := int64(float64(c.maxMemory) * c.lowWatermark)
+	return (c.maxItems > 0 && len(c.items)+n > items) ||
+		(c.maxMemory > 0 && c.usedBytes.Load()+bytes > memory)
+}
+
+// resetPolicy starts over with empty policies and namespace usage, if the
+// cache is bounded. It is called whenever all items are dropped.
+func (c *Cache) resetPolicy() {
+	if !c.bounded() {
+		return
+	}
+	c.policyMu.Lock()
+	if c.newPolicy == nil {
+		c.newPolicy = NewLRUPolicy
+	}
+	c.policies = [numPriorities]EvictionPolicy{}
+	for _, ns := range c.namespaces {
+		*ns = namespace{maxItems: ns.maxItems, maxBytes: ns.maxBytes}
+	}
+	c.policyMu.Unlock()
+}
+
+// The policy has its own mutex so reads can record accesses while holding
+// only the read lock. Writers take it after the cache lock, never before.
+
+func (c *Cache) policySet(key string, item Item) {
+	if !c.bounded() {
+		return
+	}
+	c.policyMu.Lock()
+	c.policyOf(key, item).OnSet(key)
+	c.policyMu.Unlock()
+}
+
+func (c *Cache) policyGet(key string, item Item) {
+	if !c.bounded() {
+		return
+	}
+	c.policyMu.Lock()
+	c.policyOf(key, item).OnGet(key)
+	c.policyMu.Unlock()
+}
+
+func (c *Cache) policyDelete(key string, item Item) {
+	if !c.bounded() {
+		return
+	}
+	c.policyMu.Lock()
+	c.policyOf(key, item).OnDelete(key)
+	c.policyMu.Unlock()
+}
+
+// admit asks an admission filtering policy whether a new key may be stored.
+// The caller holds the write lock.
+func (c *Cache) admit(key string, item Item) bool {
+	if !c.overLimit(1, item.size) && !c.namespaceOf(key).overLimit(1, item.size) {
+		return true
+	}
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+
+	if a, ok := c.policyOf(key, item).(Admitter); ok && !a.Admit(key) {
+		c.rejected.Add(1)
+		return false
+	}
+	return true
+}
+
+// evict removes items until n more taking bytes fit within the limits,
+// or within the low watermark once a limit was hit. Once only keys of
+// namespaces are left, those of writer, the namespace of the key written,
+// go first. The caller holds the write lock.
+func (c *Cache) evict(n int, bytes int64, writer *namespace) {
+	if !c.overLimit(n, bytes) {
+		return
+	}
+	c.evictWhile(nil, writer, func() bool { return c.overWatermark(n, bytes) })
+}
+
+// evictNamespace is evict for the quota of the namespace, if any.
+func (c *Cache) evictNamespace(ns *namespace, n int, bytes int64) {
+	if !ns.overLimit(n, bytes) {
+		return
+	}
+	c.evictWhile(ns, nil, func() bool { return ns.overWatermark(n, bytes, c.lowWatermark) })
+}
+
+// evictWhile evicts victims as long as more is true and the policy has any,
+// only from ns unless it is nil. The caller holds the write lock.
+func (c *Cache) evictWhile(ns, writer *namespace, more func() bool) {
+	if !c.bounded() {
+		return
+	}
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+
+	for more() {
+		key, ok := c.victim(ns, writer)
+		if !ok {
+			return
+		}
+		c.evicted(key, c.items[key], ReasonCapacity)
+		c.dropItem(key)
+	}
+}
+
+// EvictN evicts up to n items chosen by the policy and returns how many it
+// did. Caches without WithMaxItems, WithMaxMemory or WithMemoryPressure
+// have no policy to ask.
+func (c *Cache) EvictN(n int) int {
+	if c.shards != nil {
+		return c.evictShards(n)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	count := len(c.items)
+	c.evictWhile(nil, nil, func() bool { return count-len(c.items) < n })
+	return count - len(c.items)
+}
+
+// EvictToSize evicts items chosen by the policy until the estimated memory
+// used is at most bytes and returns how many it evicted. Memory is only
+// accounted with WithMaxMemory.
+func (c *Cache) EvictToSize(bytes int64) int {
+	if c.shards != nil {
+		return c.evictShardsToSize(bytes)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if c.maxMemory <= 0 {
+		return 0
+	}
+	count := len(c.items)
+	c.evictWhile(nil, nil, func() bool { return c.usedBytes.Load() > bytes })
+	return count - len(c.items)
+}
+
+// EvictionReason tells OnEvicted why an item left the cache.
+type EvictionReason int
+
+const (
+	ReasonExpired  EvictionReason = iota // deadline passed, removed by the GC or a read
+	ReasonCapacity                       // made room within WithMaxItems or WithMaxMemory
+	ReasonDeleted                        // removed by Delete and friends
+	ReasonReplaced                       // overwritten by a new value for the key
+	ReasonFlushed                        // removed by Flush
+
+	numReasons = iota
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonReplaced:
+		return "replaced"
+	case ReasonFlushed:
+		return "flushed"
+	}
+	return "unknown"
+}
+
+// OnEvicted registers f to be called with every item that leaves the cache
+// and the reason why. f runs once the operation removing the item has
+// released the lock; nil unregisters it.
+func (c *Cache) OnEvicted(f func(key string, value interface{}, reason EvictionReason)) {
+	for _, s := range c.shards {
+		s.OnEvicted(f)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.onEvicted = f
+}
+
+type eviction struct {
+	key        string
+	value      interface{}
+	expiration int64
+	reason     EvictionReason
+	forget     bool // only drop the key from the overflow store
+}
+
+// evicted queues the item for OnEvicted. The caller holds the write lock.
+func (c *Cache) evicted(key string, item Item, reason EvictionReason) {
+	c.evictions[reason].Add(1)
+	if reason != ReasonFlushed {
+		c.logEvent(eventOp(reason), key)
+	}
+	switch reason {
+	case ReasonDeleted:
+		c.stats.deletes.Add(1)
+	case ReasonExpired:
+		c.stats.expirations.Add(1)
+	case ReasonCapacity:
+		c.stats.evictions.Add(1)
+	}
+	if c.onEvicted != nil || c.logger != nil || (c.overflow != nil && reason == ReasonCapacity) {
+		c.pendingEvictions = append(c.pendingEvictions, eviction{
+			key:        key,
+			value:      item.Value,
+			expiration: item.Expiration,
+			reason:     reason,
+		})
+	}
+}
+
+// replaced reports the item a new value is written over. Expired items
+// were already gone for readers, so they are reported as such.
+func (c *Cache) replaced(key string, old Item) {
+	if old.expired(c.now()) {
+		c.evicted(key, old, ReasonExpired)
+		return
+	}
+	c.evicted(key, old, ReasonReplaced)
+}
+
+// Unlock publishes the writes for lock-free readers, releases the write lock
+// and then runs OnEvicted and the overflow store for the items removed while
+// it was held, so they may use the cache.
+func (c *Cache) Unlock() {
+	c.publish()
+	pending := c.pendingEvictions
+	c.pendingEvictions = nil
+	onEvicted := c.onEvicted
+	c.RWMutex.Unlock()
+
+	for _, e := range pending {
+		if e.forget {
+			c.overflow.Delete(e.key)
+			continue
+		}
+		if e.reason == ReasonCapacity && c.overflow != nil && (e.expiration == 0 || e.expiration > c.now()) {
+			c.overflow.Put(e.key, e.value, c.expirationTime(e.expiration))
+		}
+		c.log(LogDebug, "item evicted", "key", e.key, "reason", e.reason.String())
+		if onEvicted != nil {
+			onEvicted(e.key, e.value, e.reason)
+		}
+	}
+}
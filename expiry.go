@@ -0,0 +1,221 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventBuffer = 1024
+
+	maxSampleRounds = 16
+	// another round is sampled while more than 1/sampleRepeatRatio
+	// of the sampled items were expired
+	sampleRepeatRatio = 4
+)
+
+// WithSampledExpiration makes the GC check only sampleSize random expiring
+// items per round instead of deleting everything that has expired. Rounds
+// repeat while a large share of the sample turns out expired, like in Redis.
+// This bounds the time the write lock is held on very large caches.
+func WithSampledExpiration(sampleSize int) Option {
+	return func(c *Cache) {
+		c.sampleSize = sampleSize
+	}
+}
+
+type ExpiredEvent struct {
+	Key        string
+	Value      interface{}
+	Expiration time.Time
+}
+
+// WithExpirationBuffer sets the capacity of the Expirations channel.
+func WithExpirationBuffer(n int) Option {
+	return func(c *Cache) {
+		c.eventBuffer = n
+	}
+}
+
+// Expirations returns a channel receiving every item that expired from now on.
+// Events are dropped rather than blocking the cache when the buffer is full,
+// see DroppedExpirations. The channel is closed by Close.
+func (c *Cache) Expirations() <-chan ExpiredEvent {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.events == nil {
+		c.events = make(chan ExpiredEvent, c.eventBuffer)
+		if c.closed {
+			close(c.events)
+		}
+		// the shards send to the same channel, only Close closes it
+		for _, s := range c.shards {
+			s.Lock()
+			s.events = c.events
+			s.Unlock()
+		}
+	}
+	return c.events
+}
+
+// DroppedExpirations returns how many events did not fit in the Expirations buffer.
+func (c *Cache) DroppedExpirations() uint64 {
+	if c.shards != nil {
+		return c.droppedShardExpirations()
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.droppedEvents
+}
+
+// expireItem deletes an expired item and publishes it. The caller holds the
+// write lock, which also keeps Close from closing the channel under us.
+func (c *Cache) expireItem(key string, item Item) {
+	c.removeItem(key, ReasonExpired)
+	if c.events == nil {
+		return
+	}
+	select {
+	case c.events <- ExpiredEvent{Key: key, Value: item.Value, Expiration: c.wallTime(item.Expiration)}:
+	default:
+		c.droppedEvents++
+	}
+}
+
+// expiryHeap is a min-heap of item deadlines, so the GC only has to look
+// at the items that are actually expired instead of scanning the whole map.
+type expiryHeap []*expiryEntry
+
+type expiryEntry struct {
+	key   string
+	at    int64
+	index int
+}
+
+// Items are map values updated in place, their heap entries are the only
+// allocation of a write. Entries are recycled, as under churn every Set
+// of a new key would otherwise allocate one and every delete drop one.
+var expiryEntries = sync.Pool{
+	New: func() interface{} { return new(expiryEntry) },
+}
+
+func newExpiryEntry(key string, at int64) *expiryEntry {
+	e := expiryEntries.Get().(*expiryEntry)
+	e.key, e.at = key, at
+	return e
+}
+
+func freeExpiryEntry(e *expiryEntry) {
+	*e = expiryEntry{}
+	expiryEntries.Put(e)
+}
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*expiryEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// update keeps the heap in line with a new deadline for key. It returns the
+// entry to keep in the item, or nil if the item no longer expires.
+func (h *expiryHeap) update(e *expiryEntry, key string, at int64) *expiryEntry {
+	switch {
+	case at > 0 && e != nil:
+		e.at = at
+		heap.Fix(h, e.index)
+	case at > 0:
+		e = newExpiryEntry(key, at)
+		heap.Push(h, e)
+	case e != nil:
+		heap.Remove(h, e.index)
+		freeExpiryEntry(e)
+		e = nil
+	}
+	return e
+}
+
+func (h *expiryHeap) remove(e *expiryEntry) {
+	if e != nil {
+		heap.Remove(h, e.index)
+		freeExpiryEntry(e)
+	}
+}
+
+// expired returns the keys with deadlines before now without modifying the
+// heap. Subtrees whose root is not expired are skipped.
+func (h expiryHeap) expired(now int64) (keys []string) {
+	var walk func(i int)
+	walk = func(i int) {
+		if i >= len(h) || h[i].at >= now {
+			return
+		}
+		keys = append(keys, h[i].key)
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return
+}
+
+// expireSampled deletes expired items found by sampling and returns their keys
+// along with the number of items sampled.
+func (c *Cache) expireSampled() (keys []string, total int) {
+	for round := 0; round < maxSampleRounds; round++ {
+		removed, sampled, onExpired := c.expireSample()
+		total += sampled
+		for _, r := range removed {
+			keys = append(keys, r.key)
+			if onExpired != nil {
+				onExpired(r.key, r.item.Value)
+			}
+		}
+		if sampled == 0 || len(removed)*sampleRepeatRatio <= sampled {
+			break
+		}
+		c.gcPause()
+	}
+	return
+}
+
+func (c *Cache) expireSample() (removed []keyItem, sampled int, onExpired func(string, interface{})) {
+	c.Lock()
+	defer c.Unlock()
+	defer c.gcHolding(c.clock.Now())
+
+	onExpired = c.onExpired
+	now := c.purgeTime()
+	for ; sampled < c.sampleSize; sampled++ {
+		// the heap root is the earliest deadline: nothing left to find
+		if len(c.expiries) == 0 || c.expiries[0].at >= now {
+			break
+		}
+		e := c.expiries[rand.Intn(len(c.expiries))]
+		if now > e.at {
+			r := keyItem{e.key, c.items[e.key]}
+			removed = append(removed, r)
+			c.expireItem(r.key, r.item)
+		}
+	}
+	return
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+)
+
+var ErrNameTaken = errors.New("Name is already published")
+
+// expvarMu makes checking and publishing a name one step, as
+// expvar.Publish panics on a name taken in between.
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the live Stats and CapacityStats of the cache
+// under name, for /debug/vars. Names can only be published once per process.
+func (c *Cache) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return ErrNameTaken
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		stats, capacity := c.Stats(), c.CapacityStats()
+		evictions := make(map[string]uint64, len(capacity.Evictions))
+		for reason, n := range capacity.Evictions {
+			evictions[reason.String()] = n
+		}
+		return map[string]interface{}{
+			"hits":        stats.Hits,
+			"misses":      stats.Misses,
+			"hitRatio":    stats.HitRatio(),
+			"sets":        stats.Sets,
+			"deletes":     stats.Deletes,
+			"expirations": stats.Expirations,
+			"items":       capacity.Items,
+			"maxItems":    capacity.MaxItems,
+			"usedBytes":   capacity.UsedBytes,
+			"maxBytes":    capacity.MaxBytes,
+			"evictions":   evictions,
+			"rejected":    capacity.Rejected,
+			"oversized":   capacity.Oversized,
+		}
+	}))
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// GCCycle describes a finished cleanup pass.
+type GCCycle struct {
+	Scanned  int // items that can expire, or the ones sampled
+	Expired  int // items deleted
+	Backlog  int // expired items left for the next pass
+	Duration time.Duration
+	LockHeld time.Duration // time the pass held the lock of the cache or its shards
+}
+
+// OnGCCycle registers f to be called after every cleanup pass of the GC,
+// such as to alert when the Backlog keeps growing; nil unregisters it.
+func (c *Cache) OnGCCycle(f func(cycle GCCycle)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onGCCycle = f
+}
+
+// gcHolding adds the time since start to the lock held by the current pass.
+func (c *Cache) gcHolding(start time.Time) {
+	c.gcHeld.Add(int64(c.clock.Now().Sub(start)))
+}
+
+// gcCycleDone completes cycle with what the shards recorded and reports it.
+func (c *Cache) gcCycleDone(cycle GCCycle) {
+	c.RLock()
+	f := c.onGCCycle
+	c.RUnlock()
+
+	caches := c.shards
+	if caches == nil {
+		caches = []*Cache{c}
+	}
+	for _, s := range caches {
+		cycle.LockHeld += time.Duration(s.gcHeld.Swap(0))
+		if f != nil {
+			s.RLock()
+			cycle.Backlog += len(s.expiries.expired(s.purgeTime()))
+			s.RUnlock()
+		}
+	}
+	if f != nil {
+		f(cycle)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Typed is a type safe view of a Cache for keys of type K and values of
+// type V. With string keys the underlying Cache, returned by Untyped, holds
+// the values as they are. Other keys are stored under an encoding of their
+// value that keeps apart keys of different dynamic types, the values wrapped
+// in a TypedEntry along with their keys; that is the type to register with
+// gob.Register to Save such a cache.
+type Typed[K comparable, V any] struct {
+	c *Cache
+}
+
+// TypedEntry is what a Typed cache with keys other than strings stores.
+type TypedEntry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// NewTyped creates a Cache like New and returns the typed view of it.
+func NewTyped[K comparable, V any](defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Typed[K, V] {
+	return &Typed[K, V]{c: New(defaultExpiration, cleanupInterval, opts...)}
+}
+
+// Untyped returns the underlying cache, for the options and statistics
+// not repeated by Typed.
+func (t *Typed[K, V]) Untyped() *Cache {
+	return t.c
+}
+
+func (t *Typed[K, V]) Set(key K, value V, duration time.Duration) error {
+	return t.c.Set(t.keyOf(key), t.wrap(key, value), duration)
+}
+
+func (t *Typed[K, V]) Add(key K, value V, duration time.Duration) error {
+	return t.c.Add(t.keyOf(key), t.wrap(key, value), duration)
+}
+
+func (t *Typed[K, V]) Replace(key K, value V, duration time.Duration) error {
+	return t.c.Replace(t.keyOf(key), t.wrap(key, value), duration)
+}
+
+func (t *Typed[K, V]) Get(key K) (value V, found bool) {
+	v, found := t.c.Get(t.keyOf(key))
+	if !found {
+		return value, false
+	}
+	return t.valueOf(key, v)
+}
+
+func (t *Typed[K, V]) GetWithExpiration(key K) (value V, expiration time.Time, found bool) {
+	v, expiration, found := t.c.GetWithExpiration(t.keyOf(key))
+	if !found {
+		return value, expiration, false
+	}
+	if value, found = t.valueOf(key, v); !found {
+		return value, time.Time{}, false
+	}
+	return value, expiration, true
+}
+
+// GetOrSet stores the value of valueFn over an entry that belongs to
+// another key, as Get does not find it either.
+func (t *Typed[K, V]) GetOrSet(key K, valueFn func() V, duration time.Duration) (value V, loaded bool, err error) {
+	v, loaded, err := t.c.GetOrSet(t.keyOf(key), func() interface{} {
+		return t.wrap(key, valueFn())
+	}, duration)
+	if err != nil {
+		return value, false, err
+	}
+	if value, found := t.valueOf(key, v); found || !loaded {
+		return value, loaded, nil
+	}
+	value = valueFn()
+	return value, false, t.Set(key, value, duration)
+}
+
+func (t *Typed[K, V]) GetAll() map[K]V {
+	all := t.c.GetAll()
+	values := make(map[K]V, len(all))
+	for k, v := range all {
+		key, value := t.unwrap(t.stringKey(k), v)
+		values[key] = value
+	}
+	return values
+}
+
+// Keys reads the stored entries without copying them for WithCopyOnRead.
+func (t *Typed[K, V]) Keys() []K {
+	if t.stringKeys() {
+		names := t.c.Keys()
+		keys := make([]K, len(names))
+		for i, name := range names {
+			keys[i] = t.stringKey(name)
+		}
+		return keys
+	}
+	caches := t.c.shards
+	if caches == nil {
+		caches = []*Cache{t.c}
+	}
+	var keys []K
+	for _, s := range caches {
+		for _, e := range s.unexpired() {
+			key, _ := t.unwrap(*new(K), e.item.Value)
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (t *Typed[K, V]) Delete(key K) error {
+	return t.c.Delete(t.keyOf(key))
+}
+
+func (t *Typed[K, V]) Exists(key K) bool {
+	return t.c.Exists(t.keyOf(key))
+}
+
+func (t *Typed[K, V]) Count() int {
+	return t.c.Count()
+}
+
+func (t *Typed[K, V]) Flush() {
+	t.c.Flush()
+}
+
+func (t *Typed[K, V]) Close() error {
+	return t.c.Close()
+}
+
+// stringKeys reports whether K is string, whose keys are stored as they are.
+func (t *Typed[K, V]) stringKeys() bool {
+	_, ok := any(*new(K)).(string)
+	return ok
+}
+
+func (t *Typed[K, V]) keyOf(key K) string {
+	if t.stringKeys() {
+		return any(key).(string)
+	}
+	return string(appendKey(nil, reflect.ValueOf(&key).Elem()))
+}
+
+func (t *Typed[K, V]) wrap(key K, value V) interface{} {
+	if t.stringKeys() {
+		return value
+	}
+	return TypedEntry[K, V]{key, value}
+}
+
+// unwrap returns the key and value of what is stored for key, which
+// only entries of keys other than strings carry themselves.
+func (t *Typed[K, V]) unwrap(key K, v interface{}) (K, V) {
+	if e, ok := v.(TypedEntry[K, V]); ok {
+		return e.Key, e.Value
+	}
+	value, _ := v.(V)
+	return key, value
+}
+
+// valueOf is the value stored for key, not found when the entry belongs to
+// another key.
+func (t *Typed[K, V]) valueOf(key K, v interface{}) (V, bool) {
+	stored, value := t.unwrap(key, v)
+	if stored != key {
+		var zero V
+		return zero, false
+	}
+	return value, true
+}
+
+// stringKey is the K of a stored key, for caches with string keys.
+func (t *Typed[K, V]) stringKey(name string) K {
+	key, _ := any(name).(K)
+	return key
+}
+
+// appendKey encodes a comparable value so that two values encode the same
+// only when they are equal: interfaces are tagged with their dynamic type,
+// negative zero is written as zero and strings are quoted so that the
+// elements of arrays and structs can not run into each other.
+func appendKey(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return append(b, "nil"...)
+		}
+		e := v.Elem()
+		b = append(b, typeName(e.Type())...)
+		b = append(b, '(')
+		b = appendKey(b, e)
+		return append(b, ')')
+	case reflect.String:
+		return strconv.AppendQuote(b, v.String())
+	case reflect.Bool:
+		return strconv.AppendBool(b, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(b, v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(b, v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(b, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		b = appendFloat(append(b, '('), real(c))
+		b = appendFloat(append(b, ','), imag(c))
+		return append(b, ')')
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return strconv.AppendUint(append(b, "0x"...), uint64(v.Pointer()), 16)
+	case reflect.Array:
+		b = append(b, '[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendKey(b, v.Index(i))
+		}
+		return append(b, ']')
+	case reflect.Struct:
+		b = append(b, '{')
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendKey(b, v.Field(i))
+		}
+		return append(b, '}')
+	}
+	// not comparable, and so no key of a Typed cache
+	return fmt.Appendf(b, "%#v", v)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	if f == 0 {
+		f = 0
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, 64)
+}
+
+// typeName names a type by its package path too, so that two types of the
+// same name in different packages differ.
+func typeName(t reflect.Type) string {
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
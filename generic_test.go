@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTypedKeys(t *testing.T) {
+	c := NewTyped[any, string](0, 0)
+	c.Set(1, "int", NoExpiration)
+	c.Set(int64(1), "int64", NoExpiration)
+	c.Set("1", "string", NoExpiration)
+	c.Set("int(1)", "formatted", NoExpiration)
+	c.Set([2]any{"a,b", "c"}, "split", NoExpiration)
+	c.Set([2]any{"a", "b,c"}, "joined", NoExpiration)
+	for key, want := range map[any]string{
+		1:                  "int",
+		int64(1):           "int64",
+		"1":                "string",
+		"int(1)":           "formatted",
+		[2]any{"a,b", "c"}: "split",
+		[2]any{"a", "b,c"}: "joined",
+	} {
+		if v, found := c.Get(key); !found || v != want {
+			t.Errorf("%#v = %q, %v, want %q", key, v, found, want)
+		}
+	}
+	if n := c.Count(); n != 6 {
+		t.Errorf("count = %d, want 6", n)
+	}
+
+	f := NewTyped[float64, string](0, 0)
+	f.Set(0, "zero", NoExpiration)
+	if v, found := f.Get(math.Copysign(0, -1)); !found || v != "zero" {
+		t.Errorf("negative zero = %q, %v", v, found)
+	}
+}
+
+func TestTypedForeignEntry(t *testing.T) {
+	c := NewTyped[int, string](0, 0)
+	c.Set(1, "one", NoExpiration)
+	// an entry stored for 2 under the name of 1
+	c.Untyped().Set(c.keyOf(1), TypedEntry[int, string]{2, "two"}, NoExpiration)
+	if v, found := c.Get(1); found {
+		t.Errorf("found %q for another key", v)
+	}
+	if _, _, found := c.GetWithExpiration(1); found {
+		t.Error("GetWithExpiration found another key")
+	}
+	v, loaded, err := c.GetOrSet(1, func() string { return "uno" }, NoExpiration)
+	if v != "uno" || loaded || err != nil {
+		t.Errorf("GetOrSet = %q, %v, %v", v, loaded, err)
+	}
+	if v, _ := c.Get(1); v != "uno" {
+		t.Errorf("after GetOrSet 1 = %q", v)
+	}
+}
+
+func TestTypedGetOrSet(t *testing.T) {
+	clock := newTestClock()
+	c := NewTyped[int, string](0, 0, WithClock(clock))
+	v, loaded, _ := c.GetOrSet(1, func() string { return "one" }, time.Minute)
+	if v != "one" || loaded {
+		t.Errorf("first GetOrSet = %q, %v", v, loaded)
+	}
+	v, loaded, _ = c.GetOrSet(1, func() string { return "again" }, time.Minute)
+	if v != "one" || !loaded {
+		t.Errorf("second GetOrSet = %q, %v", v, loaded)
+	}
+	clock.Advance(2 * time.Minute)
+	if v, loaded, _ = c.GetOrSet(1, func() string { return "new" }, time.Minute); v != "new" || loaded {
+		t.Errorf("GetOrSet after expiry = %q, %v", v, loaded)
+	}
+}
+
+func TestTypedSave(t *testing.T) {
+	gob.Register(TypedEntry[int, string]{})
+	src := NewTyped[int, string](0, 0)
+	src.Set(1, "one", NoExpiration)
+	var buf bytes.Buffer
+	if err := src.Untyped().Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewTyped[int, string](0, 0)
+	if err := dst.Untyped().Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get(1); v != "one" {
+		t.Errorf("got %q", v)
+	}
+	if keys := dst.Keys(); len(keys) != 1 || keys[0] != 1 {
+		t.Errorf("keys = %v", keys)
+	}
+}
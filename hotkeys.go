@@ -0,0 +1,124 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// hotKeySampleRate is how many reads there are per read counted by the
+// hot key tracker, so it stays off the fast path most of the time.
+const hotKeySampleRate = 16
+
+// WithHotKeys tracks the most read keys for TopKeys, keeping counts for up
+// to capacity candidates. Reads are sampled, the counts are estimates.
+func WithHotKeys(capacity int) Option {
+	return func(c *Cache) {
+		if capacity <= 0 {
+			return
+		}
+		c.hot = &hotKeys{capacity: capacity, counts: make(map[string]*hotCount, capacity)}
+	}
+}
+
+// HotKey is a key along with the estimated number of times it was read.
+type HotKey struct {
+	Key   string
+	Count uint64
+}
+
+// hotKeys counts sampled reads with the Space-Saving algorithm: once all
+// candidates are taken, a new key replaces the least counted one and
+// inherits its count, so keys read often enough are never lost. The
+// candidates are kept in a min-heap by count as well, so that is O(log n).
+type hotKeys struct {
+	mu       sync.Mutex
+	capacity int
+	counts   map[string]*hotCount
+	least    hotHeap
+}
+
+type hotCount struct {
+	key   string
+	count uint64
+	index int // position in hotKeys.least
+}
+
+type hotHeap []*hotCount
+
+func (h hotHeap) Len() int           { return len(h) }
+func (h hotHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h hotHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotHeap) Push(x interface{}) {
+	e := x.(*hotCount)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *hotHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+func (h *hotKeys) record(key string) {
+	if h == nil || rand.Intn(hotKeySampleRate) != 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e, found := h.counts[key]; found {
+		e.count++
+		heap.Fix(&h.least, e.index)
+		return
+	}
+	if len(h.counts) < h.capacity {
+		e := &hotCount{key: key, count: 1}
+		h.counts[key] = e
+		heap.Push(&h.least, e)
+		return
+	}
+	e := h.least[0]
+	delete(h.counts, e.key)
+	e.key = key
+	e.count++
+	h.counts[key] = e
+	heap.Fix(&h.least, 0)
+}
+
+// TopKeys returns the n most read keys, most read first, or nil unless
+// the cache was created with WithHotKeys.
+func (c *Cache) TopKeys(n int) []HotKey {
+	h := c.hot
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	top := make([]HotKey, 0, len(h.counts))
+	for k, e := range h.counts {
+		top = append(top, HotKey{Key: k, Count: e.count * hotKeySampleRate})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
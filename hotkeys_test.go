@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopKeys(t *testing.T) {
+	c := New(0, 0, WithShards(2), WithHotKeys(8))
+	c.Set("hot", 1, NoExpiration)
+	for i := 0; i < 5000; i++ {
+		c.Get("hot")
+		c.Get(strings.Repeat("k", i%50+1))
+	}
+	top := c.TopKeys(1)
+	if len(top) != 1 || top[0].Key != "hot" {
+		t.Errorf("top keys = %v", top)
+	}
+}
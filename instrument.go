@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Instrumenter records the operations of an Instrumented cache, for example
+// as OpenTelemetry spans and metrics. Start begins an operation and returns
+// the context to finish it with, such as one carrying a new span.
+type Instrumenter interface {
+	Start(ctx context.Context, op string) context.Context
+	Finish(ctx context.Context, info OpInfo)
+}
+
+// OpInfo describes a finished operation as the attributes to record.
+type OpInfo struct {
+	Op        string // "get", "set" or "delete"
+	KeyHash   uint64 // the hash of the key, not the key itself
+	Hit       bool   // whether a get found a value
+	ValueSize int64  // estimated size of the value read or written
+	Duration  time.Duration
+	Err       error
+}
+
+// Instrumented is a Cache reporting Get, Set and Delete to an Instrumenter.
+// The methods taking a context tie the operations to the caller's trace.
+type Instrumented struct {
+	*Cache
+	inst Instrumenter
+}
+
+func NewInstrumented(c *Cache, inst Instrumenter) *Instrumented {
+	return &Instrumented{Cache: c, inst: inst}
+}
+
+func (i *Instrumented) Get(key string) (interface{}, bool) {
+	return i.GetContext(context.Background(), key)
+}
+
+func (i *Instrumented) Set(key string, value interface{}, duration time.Duration) error {
+	return i.SetContext(context.Background(), key, value, duration)
+}
+
+func (i *Instrumented) Delete(key string) error {
+	return i.DeleteContext(context.Background(), key)
+}
+
+func (i *Instrumented) GetContext(ctx context.Context, key string) (interface{}, bool) {
+	ctx = i.inst.Start(ctx, "get")
+	start := time.Now()
+	value, found := i.Cache.Get(key)
+	info := OpInfo{Op: "get", KeyHash: i.hash(key), Hit: found, Duration: time.Since(start)}
+	if found {
+		info.ValueSize = sizeOf(value)
+	}
+	i.inst.Finish(ctx, info)
+	return value, found
+}
+
+func (i *Instrumented) SetContext(ctx context.Context, key string, value interface{}, duration time.Duration) error {
+	ctx = i.inst.Start(ctx, "set")
+	start := time.Now()
+	err := i.Cache.Set(key, value, duration)
+	i.inst.Finish(ctx, OpInfo{
+		Op:        "set",
+		KeyHash:   i.hash(key),
+		ValueSize: sizeOf(value),
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+	return err
+}
+
+func (i *Instrumented) DeleteContext(ctx context.Context, key string) error {
+	ctx = i.inst.Start(ctx, "delete")
+	start := time.Now()
+	err := i.Cache.Delete(key)
+	i.inst.Finish(ctx, OpInfo{Op: "delete", KeyHash: i.hash(key), Duration: time.Since(start), Err: err})
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WithAccessTracking counts the reads of every item and remembers the
+// last one, for GetItem. Reads then also write to a counter of the item.
+func WithAccessTracking() Option {
+	return func(c *Cache) {
+		c.trackAccess = true
+	}
+}
+
+// itemAccess is shared by all copies of an item, so reads can update it
+// without the write lock.
+type itemAccess struct {
+	hits atomic.Uint64
+	last atomic.Int64 // cache clock time of the last read, 0 before the first
+}
+
+// ItemInfo is the metadata of an item, as returned by GetItem.
+type ItemInfo struct {
+	Key        string
+	Created    time.Time
+	Expiration time.Time // zero if the item never expires
+	Version    uint64
+	Pinned     bool
+	Priority   Priority
+	Size       int64     // estimated, only accounted with WithMaxMemory
+	Hits       uint64    // reads since the key was added, with WithAccessTracking
+	LastAccess time.Time // of the last read, with WithAccessTracking or sliding expiration
+}
+
+// GetItem returns the metadata of the unexpired item stored for key.
+// Unlike the other reads it does not count as an access.
+func (c *Cache) GetItem(key string) (ItemInfo, bool) {
+	if c.shards != nil {
+		return c.shard(key).GetItem(key)
+	}
+	item, found := c.lookup(key)
+	if !found || item.expired(c.now()) {
+		return ItemInfo{}, false
+	}
+	info := ItemInfo{
+		Key:        key,
+		Created:    item.Created,
+		Version:    item.Version,
+		Pinned:     item.Pinned,
+		Priority:   item.Priority,
+		Size:       item.size,
+		LastAccess: item.LastAccess,
+	}
+	if item.Expiration > 0 {
+		info.Expiration = c.wallTime(item.Expiration)
+	}
+	if a := item.access; a != nil {
+		info.Hits = a.hits.Load()
+		if last := a.last.Load(); last > 0 {
+			info.LastAccess = c.wallTime(last)
+		}
+	}
+	return info, true
+}
+
+// accessed counts a read of the item found.
+func (c *Cache) accessed(item Item) {
+	if a := item.access; a != nil {
+		a.hits.Add(1)
+		a.last.Store(c.now())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotJSONArray is returned by ImportJSON for input that is not the array
+// ExportJSON writes.
+var ErrNotJSONArray = errors.New("JSON import is not an array of items")
+
+// jsonItem is an item as written by ExportJSON.
+type jsonItem struct {
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	Created    time.Time       `json:"created"`
+	Expiration *time.Time      `json:"expiration,omitempty"`
+}
+
+// ExportJSON writes the unexpired items to w as a JSON array of objects
+// with their key, value, creation time and expiration, omitted for items
+// that never expire. Values are written with encoding/json, or if codec is
+// not nil, as the base64 of what codec makes of them, for values JSON does
+// not round trip.
+func (c *Cache) ExportJSON(w io.Writer, codec Codec) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	sep := "\n"
+	now := c.clock.Now()
+	err := c.eachSaved(func(saved savedItem) error {
+		value, err := marshalJSONValue(saved.Value, codec)
+		if err != nil {
+			return err
+		}
+		item := jsonItem{Key: saved.Key, Value: value, Created: saved.Created}
+		if saved.TTL > 0 {
+			expiration := now.Add(saved.TTL)
+			item.Expiration = &expiration
+		}
+		data, err := json.Marshal(&item)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep+string(data)); err != nil {
+			return err
+		}
+		sep = ",\n"
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	end := "]\n"
+	if sep != "\n" {
+		end = "\n]\n"
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+// ImportJSON adds the items written by ExportJSON from r, decoding the
+// values with the codec they were exported with. Values exported without
+// a codec come back as encoding/json decodes them into an interface{}:
+// numbers as float64, objects as map[string]interface{}. Items whose
+// expiration has passed are skipped.
+func (c *Cache) ImportJSON(r io.Reader, codec Codec) error {
+	dec := json.NewDecoder(r)
+	start, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if start != json.Delim('[') {
+		return ErrNotJSONArray
+	}
+	for dec.More() {
+		var item jsonItem
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		saved := savedItem{Key: item.Key, Created: item.Created}
+		if item.Expiration != nil {
+			saved.TTL = item.Expiration.Sub(c.clock.Now())
+			if saved.TTL <= 0 {
+				continue
+			}
+		}
+		value, err := unmarshalJSONValue(item.Value, codec)
+		if err != nil {
+			return err
+		}
+		saved.Value = value
+		if err := c.restore(saved); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func marshalJSONValue(value interface{}, codec Codec) (json.RawMessage, error) {
+	if codec == nil {
+		return json.Marshal(value)
+	}
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func unmarshalJSONValue(raw json.RawMessage, codec Codec) (interface{}, error) {
+	var value interface{}
+	if codec == nil {
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+	var data []byte
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return codec.Unmarshal(data)
+}
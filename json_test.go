@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	clock := newTestClock()
+	src := New(0, 0, WithShards(2), WithClock(clock))
+	fillSaved(src, clock)
+
+	var buf bytes.Buffer
+	if err := src.ExportJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	dst := New(0, 0, WithClock(clock))
+	if err := dst.ImportJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, dst, clock, false)
+	if v, _ := dst.Get("int"); v != 1.0 {
+		t.Errorf("int = %#v, want JSON number", v)
+	}
+
+	buf.Reset()
+	if err := src.ExportJSON(&buf, GobCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	dst = New(0, 0, WithClock(clock))
+	if err := dst.ImportJSON(&buf, GobCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Get("struct"); v != (savedPoint{3, 4}) {
+		t.Errorf("struct = %v through the codec", v)
+	}
+}
+
+func TestExportJSONEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(0, 0).ExportJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("exported %q", buf.String())
+	}
+	if err := New(0, 0).ImportJSON(&buf, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportJSONNotArray(t *testing.T) {
+	for _, in := range []string{`{"key": "a"}`, `"a"`, `1`} {
+		c := New(0, 0)
+		if err := c.ImportJSON(strings.NewReader(in), nil); err != ErrNotJSONArray {
+			t.Errorf("%s: %v", in, err)
+		}
+		if n := c.Count(); n != 0 {
+			t.Errorf("%s: imported %d items", in, n)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "sync"
+
+// keyLockStripes is the number of mutexes LockKey shares among all keys.
+const keyLockStripes = 256
+
+// LockKey locks a mutex for the key, such as around computing its value in
+// cache-aside code, without blocking the cache. Keys share a fixed number of
+// mutexes, so holding two key locks at once can deadlock.
+func (c *Cache) LockKey(key string) {
+	c.keyLock(key).Lock()
+}
+
+// UnlockKey unlocks what LockKey locked for the key.
+func (c *Cache) UnlockKey(key string) {
+	c.keyLock(key).Unlock()
+}
+
+func (c *Cache) keyLock(key string) *sync.Mutex {
+	c.keyLocksOnce.Do(func() {
+		c.keyLocks = make([]sync.Mutex, keyLockStripes)
+	})
+	return &c.keyLocks[c.hash(key)%keyLockStripes]
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets.
+// Durations above the last fall into a final unbounded bucket.
+var latencyBuckets = [...]time.Duration{
+	250 * time.Nanosecond, 500 * time.Nanosecond,
+	time.Microsecond, 2500 * time.Nanosecond, 5 * time.Microsecond,
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// WithLatencyHistograms records how long every Get, Set and Delete takes,
+// for Latencies and WritePrometheus. Slow outliers point at lock contention.
+func WithLatencyHistograms() Option {
+	return func(c *Cache) {
+		c.latency = &latencies{}
+	}
+}
+
+// Histogram counts operations by duration. Counts[i] is the number that
+// took at most Bounds[i], the last count is for the ones above all bounds.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Quantile estimates the duration below which the share q of the
+// operations fall, as the bound of the bucket holding it. Operations above
+// all bounds are reported as the last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen > rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+type histogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) since(start time.Time) {
+	d := time.Since(start)
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: latencyBuckets[:],
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+type latencies struct {
+	get, set, delete histogram
+}
+
+// Latencies returns the histograms of "get", "set" and "delete", or nil
+// unless the cache was created with WithLatencyHistograms.
+func (c *Cache) Latencies() map[string]Histogram {
+	if c.latency == nil {
+		return nil
+	}
+	return map[string]Histogram{
+		"get":    c.latency.get.snapshot(),
+		"set":    c.latency.set.snapshot(),
+		"delete": c.latency.delete.snapshot(),
+	}
+}
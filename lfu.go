@@ -0,0 +1,82 @@
+package main
+
+import "container/list"
+
+// lfuPolicy is the O(1) LFU: keys sit in buckets of equal access count,
+// and the buckets are kept in a list ordered by that count.
+type lfuPolicy struct {
+	buckets *list.List // of *lfuBucket, lowest frequency first
+	entries map[string]*lfuEntry
+}
+
+type lfuBucket struct {
+	freq int
+	keys *list.List // of *lfuEntry, most recent first
+}
+
+type lfuEntry struct {
+	key    string
+	bucket *list.Element
+	elem   *list.Element
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{buckets: list.New(), entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) OnSet(key string) {
+	if _, found := p.entries[key]; found {
+		p.OnGet(key)
+		return
+	}
+	front := p.buckets.Front()
+	if front == nil || front.Value.(*lfuBucket).freq != 1 {
+		front = p.buckets.PushFront(&lfuBucket{freq: 1, keys: list.New()})
+	}
+	e := &lfuEntry{key: key, bucket: front}
+	e.elem = front.Value.(*lfuBucket).keys.PushFront(e)
+	p.entries[key] = e
+}
+
+func (p *lfuPolicy) OnGet(key string) {
+	e, found := p.entries[key]
+	if !found {
+		return
+	}
+	cur := e.bucket
+	freq := cur.Value.(*lfuBucket).freq
+	next := cur.Next()
+	if next == nil || next.Value.(*lfuBucket).freq != freq+1 {
+		next = p.buckets.InsertAfter(&lfuBucket{freq: freq + 1, keys: list.New()}, cur)
+	}
+	p.unlink(e)
+	e.bucket = next
+	e.elem = next.Value.(*lfuBucket).keys.PushFront(e)
+}
+
+func (p *lfuPolicy) OnDelete(key string) {
+	if e, found := p.entries[key]; found {
+		p.unlink(e)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfuPolicy) Victim() (string, bool) {
+	front := p.buckets.Front()
+	if front == nil {
+		return "", false
+	}
+	e := front.Value.(*lfuBucket).keys.Back().Value.(*lfuEntry)
+	p.unlink(e)
+	delete(p.entries, e.key)
+	return e.key, true
+}
+
+// unlink takes the entry out of its bucket, dropping the bucket once empty.
+func (p *lfuPolicy) unlink(e *lfuEntry) {
+	b := e.bucket.Value.(*lfuBucket)
+	b.keys.Remove(e.elem)
+	if b.keys.Len() == 0 {
+		p.buckets.Remove(e.bucket)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoLoader     = errors.New("No loader")
+	ErrLoadPanicked = errors.New("Loader panicked")
+)
+
+// Loader fetches the value for a key from the origin on behalf of the cache.
+type Loader func(key string) (value interface{}, duration time.Duration, err error)
+
+// WithLoader registers the loader used to refresh items in the background.
+func WithLoader(loader Loader) Option {
+	return func(c *Cache) {
+		c.loader = loader
+	}
+}
+
+// WithStaleWhileRevalidate keeps expired items for grace longer. Reading one
+// in that window returns the stale value and reloads it with the loader.
+func WithStaleWhileRevalidate(grace time.Duration) Option {
+	return func(c *Cache) {
+		c.staleGrace = grace
+	}
+}
+
+// WithRefreshAhead reloads an item in the background with the loader once
+// it is read after threshold (e.g. 0.8) of its lifetime has passed, so hot
+// keys are refreshed before they expire. The lifetime is counted from when
+// the value was stored; sliding items are not refreshed ahead.
+func WithRefreshAhead(threshold float64) Option {
+	return func(c *Cache) {
+		c.refreshAhead = threshold
+	}
+}
+
+// refreshDue reports whether a fresh item has reached the refresh-ahead threshold.
+func (c *Cache) refreshDue(item Item, now int64) bool {
+	if c.refreshAhead <= 0 || item.slide > 0 || item.expiresAt() == 0 {
+		return false
+	}
+	created := int64(item.Created.Sub(c.epoch))
+	return float64(now-created) >= c.refreshAhead*float64(item.Expiration-created)
+}
+
+// WithXFetch enables probabilistic early expiration: a read may decide to
+// reload an item before it expires, the more likely the closer the deadline
+// and the longer the loader took to produce the value. beta scales how early
+// (1 is the usual choice). Only values stored by the loader are affected.
+func WithXFetch(beta float64) Option {
+	return func(c *Cache) {
+		c.xfetchBeta = beta
+	}
+}
+
+func (c *Cache) xfetchDue(item Item, now int64) bool {
+	if c.xfetchBeta <= 0 || item.delta <= 0 || item.expiresAt() == 0 {
+		return false
+	}
+	early := -float64(item.delta) * c.xfetchBeta * math.Log(1-rand.Float64())
+	return float64(now)+early >= float64(item.Expiration)
+}
+
+// GetOrLoad returns the value, loading and storing it on a miss with load,
+// or the WithLoader loader if load is nil. Concurrent misses of a key share
+// a single call of the loader and its result.
+func (c *Cache) GetOrLoad(key string, load Loader) (interface{}, error) {
+	if c.shards != nil {
+		return c.shard(key).GetOrLoad(key, load)
+	}
+	if value, found := c.Get(key); found {
+		return value, nil
+	}
+	if load == nil {
+		load = c.loader
+	}
+	if load == nil {
+		return nil, ErrNoLoader
+	}
+
+	c.callsMu.Lock()
+	if call, found := c.calls[key]; found {
+		c.callsMu.Unlock()
+		call.wg.Wait()
+		return c.read(call.value), call.err
+	}
+	if c.calls == nil {
+		c.calls = make(map[string]*loadCall)
+	}
+	call := &loadCall{err: ErrLoadPanicked}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.callsMu.Unlock()
+
+	defer func() {
+		c.callsMu.Lock()
+		delete(c.calls, key)
+		c.callsMu.Unlock()
+		call.wg.Done()
+	}()
+
+	start := c.clock.Now()
+	value, duration, err := load(key)
+	if err != nil {
+		call.err = err
+		return nil, err
+	}
+	item := c.newItem(value, duration)
+	item.delta = c.clock.Now().Sub(start)
+
+	c.Lock()
+	err = c.setItem(key, item)
+	c.Unlock()
+	call.value, call.err = value, err
+	return c.read(value), err
+}
+
+// loadCall is a GetOrLoad in progress, waited for by the other misses.
+type loadCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// GetStale is Get that also reports whether the value is past its expiration
+// and only served because of WithStaleWhileRevalidate.
+func (c *Cache) GetStale(key string) (value interface{}, stale, found bool) {
+	return c.get(key)
+}
+
+// purgeTime is the moment expired items are removed at: stale
+// items are kept around until their grace period is over.
+func (c *Cache) purgeTime() int64 {
+	return c.now() - int64(c.staleGrace)
+}
+
+// refresh reloads the key in the background, at most once at a time.
+func (c *Cache) refresh(key string) {
+	if c.loader == nil {
+		return
+	}
+
+	c.Lock()
+	if c.refreshing[key] || c.closed {
+		c.Unlock()
+		return
+	}
+	if c.refreshing == nil {
+		c.refreshing = make(map[string]bool)
+	}
+	c.refreshing[key] = true
+	c.Unlock()
+
+	go func() {
+		defer func() {
+			c.Lock()
+			delete(c.refreshing, key)
+			c.Unlock()
+		}()
+
+		start := c.clock.Now()
+		value, duration, err := c.loader(key)
+		if err != nil {
+			c.log(LogError, "refresh failed", "key", key, "err", err)
+			return
+		}
+		item := c.newItem(value, duration)
+		item.delta = c.clock.Now().Sub(start)
+
+		c.Lock()
+		err = c.setItem(key, item)
+		c.Unlock()
+		if err != nil && err != ErrClosed {
+			c.log(LogError, "refresh failed", "key", key, "err", err)
+		}
+	}()
+}
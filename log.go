@@ -0,0 +1,29 @@
+package main
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogError
+)
+
+// Logger receives the messages of the cache, with attributes as alternating
+// keys and values. The cache logs nothing unless one is set with WithLog.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// WithLog sends the messages of the GC and the loader to logger.
+func WithLog(logger Logger) Option {
+	return func(c *Cache) {
+		c.logger = logger
+	}
+}
+
+func (c *Cache) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Log(level, msg, keyvals...)
+	}
+}
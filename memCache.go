@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,133 +19,1576 @@ type Cache struct {
 	defaultExpiration time.Duration
 	cleanupInterval time.Duration
 	items map[string]Item
+	initialCapacity int
+	clock Clock
+	epoch time.Time
+	coarseResolution time.Duration
+	coarse *atomic.Int64 // cache clock time read by now, see WithCoarseClock
+	keys []string
+	expiries expiryHeap
+	version uint64
+	sampleSize int
+	gcBatchSize int
+	gcYield time.Duration
+	minCleanup time.Duration
+	maxCleanup time.Duration
+	ttlJitter float64
+	sliding bool
+	loader Loader
+	staleGrace time.Duration
+	refreshAhead float64
+	xfetchBeta float64
+	refreshing map[string]bool
+	calls map[string]*loadCall // GetOrLoad in progress by key
+	callsMu sync.Mutex
+	maxItems int
+	maxMemory int64
+	maxValueSize int64
+	skipOversized bool
+	lowWatermark float64
+	pressureLimit uint64
+	pressureInterval time.Duration
+	usedBytes atomic.Int64
+	count atomic.Int64 // len(c.items), read by Count without locking
+	newPolicy func(capacity int) EvictionPolicy
+	policies [numPriorities]EvictionPolicy // by Priority.class, created on first use
+	policyMu sync.Mutex
+	onExpired func(key string, value interface{})
+	onEvicted func(key string, value interface{}, reason EvictionReason)
+	pendingEvictions []eviction // reported by Unlock
+	trackAccess bool
+	stats statCounters // reset by ResetStats, unlike evictions
+	eventLog *eventRing // recent events, see WithEventLog
+	sizeInterval time.Duration
+	sizeSample int
+	sizes sizeProfiler // last sample of WithSizeSampling
+	latency *latencies // of the operations, see WithLatencyHistograms
+	evictions [numReasons]atomic.Uint64
+	rejected atomic.Uint64
+	oversized atomic.Uint64
+	onGCError func(err error)
+	logger Logger
+	events chan ExpiredEvent
+	eventBuffer int
+	droppedEvents uint64
+	gcRunning bool
+	gcCycles atomic.Uint64
+	gcLast atomic.Int64 // duration of the last cycle
+	gcHeld atomic.Int64 // time the current cycle held the lock so far
+	onGCCycle func(GCCycle)
+	reschedule chan struct{}
+	stop chan struct{}
+	done <-chan struct{}
+	closed bool
+	overflow OverflowStore
+	shardCount int
+	parallelScan bool
+	cloner func(value interface{}) interface{} // see WithCopyOnRead
+	autoTune bool
+	expectedItems int // hint of WithAutoTune
+	shards []*Cache // storage is split among them with WithShards
+	seed maphash.Seed // hashes keys to shards and key locks
+	hasher func(key string) uint64 // replaces maphash, see WithHasher
+	storage StorageMode
+	readMap sync.Map // copy of items read by lookup with StorageSyncMap
+	snapshot atomic.Pointer[map[string]Item] // read by lookup with StorageSnapshot
+	snapshotDirty bool
+	keyLocks []sync.Mutex // striped, created by the first LockKey
+	keyLocksOnce sync.Once
+	hot *hotKeys // reads counted for TopKeys, see WithHotKeys
+	namespaces map[string]*namespace // with a quota, see WithNamespaceQuota
+	namespaceSeparator string
+	nsStats *sync.Map // namespace names to *namespaceCounters, see WithNamespaceStats
+	aofPath string
+	aofSync SyncPolicy
+	aof *appendLog // write-ahead log of WithAppendLog, shared with the shards
+	snapshotInterval time.Duration
+	snapshotPath string
+	onSnapshot func(SnapshotInfo)
 }
 
 type Item struct {
 	Value interface{}
 	Created time.Time
-	Expiration int64
+	Expiration int64 // nanoseconds since the cache was created, 0 if it never expires
+	Version uint64 // bumped on every write, never reused within a cache
+	LastAccess time.Time // updated by reads of sliding items only
+	Pinned bool // kept by the GC and any eviction, see Pin
+	Priority Priority // lower classes are evicted first
+	slide time.Duration // renewal period of sliding items
+	limit int64 // absolute deadline a sliding item can not be renewed past
+	delta time.Duration // how long the loader took to produce the value
+	cost int64 // declared by SetWithCost, 0 to estimate the size
+	size int64 // charged against WithMaxMemory
+	index int // position of the key in Cache.keys
+	expiry *expiryEntry
+	access *itemAccess // with WithAccessTracking
 }
 
-func New(defaultExpiration, cleanupInterval time.Duration) *Cache{
+type Option func(*Cache)
+
+func New(defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Cache{
+	return NewWithContext(context.Background(), defaultExpiration, cleanupInterval, opts...)
+}
+
+// NewWithContext is like New, but the GC goroutine also exits once ctx is done.
+// The cache itself stays usable, only expired items are no longer cleaned up.
+func NewWithContext(ctx context.Context, defaultExpiration, cleanupInterval time.Duration, opts ...Option) *Cache {
 	items:=make(map[string]Item)
 	cache := Cache{
 		defaultExpiration:defaultExpiration,
 		cleanupInterval:cleanupInterval,
 		items:items,
+		clock:systemClock{},
+		eventBuffer:defaultEventBuffer,
+		namespaceSeparator:defaultNamespaceSeparator,
+		stop:make(chan struct{}),
+		reschedule:make(chan struct{}, 1),
+		done:ctx.Done(),
+	}
+	for _, opt := range opts {
+		opt(&cache)
+	}
+	if cache.autoTune {
+		cache.tune()
+	}
+	cache.epoch = cache.clock.Now()
+	cache.seed = maphash.MakeSeed()
+	if cache.coarseResolution > 0 {
+		cache.startCoarseClock()
+	}
+	if cache.shardCount > 0 {
+		cache.newShards(defaultExpiration, opts)
+	} else if cache.initialCapacity > 0 {
+		cache.items = make(map[string]Item, cache.initialCapacity)
+		cache.keys = make([]string, 0, cache.initialCapacity)
+	}
+	cache.resetPolicy()
+	if cache.aofPath != "" {
+		cache.openAppendLog()
 	}
 	if cleanupInterval >0 {
 		cache.StartGC()
 	}
+	if cache.pressureLimit > 0 && cache.pressureInterval > 0 {
+		go cache.watchPressure()
+	}
+	if cache.sizeInterval > 0 && cache.sizeSample > 0 {
+		go cache.sampleSizesEvery()
+	}
+	if cache.snapshotInterval > 0 && cache.snapshotPath != "" {
+		go cache.snapshotEvery()
+	}
 
 	return &cache
 }
 
-func (c *Cache) Set(key string, value interface{}, duration time.Duration){
-	var expiration int64
+// Durations with a special meaning for Set and the other writes.
+// NoExpiration is also what TTL reports for items that never expire.
+const (
+	DefaultExpiration time.Duration = 0
+	NoExpiration      time.Duration = -1
+)
+
+var (
+	ErrKeyNotFound   = errors.New("Key not found")
+	ErrKeyExists     = errors.New("Key already exists")
+	ErrNotInteger    = errors.New("Value is not an integer")
+	ErrNotFloat      = errors.New("Value is not a float")
+	ErrClosed        = errors.New("Cache is closed")
+	ErrValueTooLarge = errors.New("Value is too large")
+)
+
+// Set stores the value for duration, DefaultExpiration or NoExpiration.
+func (c *Cache) Set(key string, value interface{}, duration time.Duration) error {
+	if c.latency != nil {
+		defer c.latency.set.since(time.Now())
+	}
+	if c.shards != nil {
+		return c.shard(key).Set(key, value, duration)
+	}
+	item := c.newItem(value, duration)
+
+	c.Lock()
+	defer c.Unlock()
+	return c.setItem(key, item)
+}
+
+// Add stores the value only if the key is missing or already expired.
+func (c *Cache) Add(key string, value interface{}, duration time.Duration) error {
+	if c.shards != nil {
+		return c.shard(key).Add(key, value, duration)
+	}
+	item := c.newItem(value, duration)
+
+	c.Lock()
+	defer c.Unlock()
+	if old, found := c.items[key]; found && !old.expired(c.now()) {
+		return ErrKeyExists
+	}
+	return c.setItem(key, item)
+}
+
+// Replace overwrites the value only if the key exists and is not expired.
+func (c *Cache) Replace(key string, value interface{}, duration time.Duration) error {
+	if c.shards != nil {
+		return c.shard(key).Replace(key, value, duration)
+	}
+	item := c.newItem(value, duration)
 
-	if duration == 0{
+	c.Lock()
+	defer c.Unlock()
+	if old, found := c.items[key]; !found || old.expired(c.now()) {
+		return ErrKeyNotFound
+	}
+	return c.setItem(key, item)
+}
+
+// Swap stores the value and returns the one it replaced, still there if
+// the value could not be stored.
+func (c *Cache) Swap(key string, value interface{}, duration time.Duration) (old interface{}, existed bool, err error) {
+	if c.shards != nil {
+		return c.shard(key).Swap(key, value, duration)
+	}
+	item := c.newItem(value, duration)
+
+	c.Lock()
+	defer c.Unlock()
+	if prev, found := c.items[key]; found && !prev.expired(c.now()) {
+		old, existed = prev.Value, true
+	}
+	err = c.setItem(key, item)
+	return
+}
+
+// Update replaces the value with the result of fn while holding the write lock.
+// An existing item keeps its expiration, a new one gets the default expiration.
+// If fn returns an error the cache is left unchanged. fn must not use the cache.
+func (c *Cache) Update(key string, fn func(old interface{}, exists bool) (interface{}, error)) error {
+	if c.shards != nil {
+		return c.shard(key).Update(key, fn)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if found && item.expired(c.now()) {
+		found = false
+	}
+	var old interface{}
+	if found {
+		old = item.Value
+	}
+	value, err := fn(old, found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		item = c.newItem(value, 0)
+	} else {
+		item.Value = value
+	}
+	return c.setItem(key, item)
+}
+
+// Increment adds delta to an integer value and returns the result.
+// A missing key is created as int64(delta) with the default expiration.
+func (c *Cache) Increment(key string, delta int64) (int64, error) {
+	if c.shards != nil {
+		return c.shard(key).Increment(key, delta)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		if err := c.setItem(key, c.newItem(delta, 0)); err != nil {
+			return 0, err
+		}
+		return delta, nil
+	}
+
+	var n int64
+	switch v := item.Value.(type) {
+	case int:
+		item.Value = v + int(delta)
+		n = int64(v + int(delta))
+	case int8:
+		item.Value = v + int8(delta)
+		n = int64(v + int8(delta))
+	case int16:
+		item.Value = v + int16(delta)
+		n = int64(v + int16(delta))
+	case int32:
+		item.Value = v + int32(delta)
+		n = int64(v + int32(delta))
+	case int64:
+		item.Value = v + delta
+		n = v + delta
+	case uint:
+		item.Value = v + uint(delta)
+		n = int64(v + uint(delta))
+	case uint8:
+		item.Value = v + uint8(delta)
+		n = int64(v + uint8(delta))
+	case uint16:
+		item.Value = v + uint16(delta)
+		n = int64(v + uint16(delta))
+	case uint32:
+		item.Value = v + uint32(delta)
+		n = int64(v + uint32(delta))
+	case uint64:
+		item.Value = v + uint64(delta)
+		n = int64(v + uint64(delta))
+	case uintptr:
+		item.Value = v + uintptr(delta)
+		n = int64(v + uintptr(delta))
+	default:
+		return 0, ErrNotInteger
+	}
+	if err := c.setItem(key, item); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *Cache) Decrement(key string, delta int64) (int64, error) {
+	return c.Increment(key, -delta)
+}
+
+// IncrementFloat adds delta to a float32 or float64 value and returns the result.
+// A missing key is created as delta with the default expiration.
+func (c *Cache) IncrementFloat(key string, delta float64) (float64, error) {
+	if c.shards != nil {
+		return c.shard(key).IncrementFloat(key, delta)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		if err := c.setItem(key, c.newItem(delta, 0)); err != nil {
+			return 0, err
+		}
+		return delta, nil
+	}
+
+	var n float64
+	switch v := item.Value.(type) {
+	case float32:
+		item.Value = v + float32(delta)
+		n = float64(v + float32(delta))
+	case float64:
+		item.Value = v + delta
+		n = v + delta
+	default:
+		return 0, ErrNotFloat
+	}
+	if err := c.setItem(key, item); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Touch resets the item's expiration to duration from now, keeping the value.
+func (c *Cache) Touch(key string, duration time.Duration) bool {
+	if c.shards != nil {
+		return c.shard(key).Touch(key, duration)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return false
+	}
+	item.Expiration = item.withinLimit(c.expiration(duration))
+	if item.slide > 0 {
+		item.slide = c.lifetime(duration)
+	}
+	return c.setItem(key, item) == nil
+}
+
+// Expire sets the item to expire after duration. Unlike Touch, zero is not
+// the default expiration: a non-positive duration deletes the item right away.
+func (c *Cache) Expire(key string, duration time.Duration) bool {
+	if c.shards != nil {
+		return c.shard(key).Expire(key, duration)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return false
+	}
+	if duration <= 0 {
+		c.removeItem(key, ReasonDeleted)
+		return true
+	}
+	item.Expiration = item.withinLimit(c.deadline(duration))
+	return c.setItem(key, item) == nil
+}
+
+// Persist removes the item's expiration. Items of SetWithIdleTimeout
+// still expire at the end of their maximum lifetime.
+func (c *Cache) Persist(key string) bool {
+	if c.shards != nil {
+		return c.shard(key).Persist(key)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return false
+	}
+	item.Expiration = item.withinLimit(0)
+	item.slide = 0
+	return c.setItem(key, item) == nil
+}
+
+func (c *Cache) newItem(value interface{}, duration time.Duration) Item {
+	now := c.clock.Now()
+	item := Item{
+		Value:      value,
+		Created:    now,
+		LastAccess: now,
+		Expiration: c.expiration(duration),
+	}
+	if c.sliding {
+		item.slide = c.lifetime(duration)
+	}
+	return item
+}
+
+// lifetime resolves the default expiration; it is 0 for items that never expire.
+func (c *Cache) lifetime(duration time.Duration) time.Duration {
+	if duration == DefaultExpiration {
 		duration = c.defaultExpiration
 	}
+	if duration < 0 {
+		return 0
+	}
+	return duration
+}
+
+// WithSlidingExpiration makes every read of an item push its expiration
+// forward by the duration it was set with, so items expire after being idle.
+func WithSlidingExpiration() Option {
+	return func(c *Cache) {
+		c.sliding = true
+	}
+}
+
+// SetSliding stores an item whose expiration is renewed on every Get,
+// regardless of WithSlidingExpiration.
+func (c *Cache) SetSliding(key string, value interface{}, duration time.Duration) error {
+	if c.shards != nil {
+		return c.shard(key).SetSliding(key, value, duration)
+	}
+	item := c.newItem(value, duration)
+	item.slide = c.lifetime(duration)
 
-	if duration > 0{
-		expiration = time.Now().Add(duration).UnixNano()
+	c.Lock()
+	defer c.Unlock()
+	return c.setItem(key, item)
+}
+
+// SetWithIdleTimeout stores an item that expires after being idle for idleTimeout,
+// but no later than maxLifetime after now however often it is read.
+func (c *Cache) SetWithIdleTimeout(key string, value interface{}, idleTimeout, maxLifetime time.Duration) error {
+	if c.shards != nil {
+		return c.shard(key).SetWithIdleTimeout(key, value, idleTimeout, maxLifetime)
+	}
+	item := c.newItem(value, idleTimeout)
+	item.slide = c.lifetime(idleTimeout)
+	if maxLifetime > 0 {
+		item.limit = c.now() + int64(maxLifetime)
+		if item.Expiration == 0 || item.Expiration > item.limit {
+			item.Expiration = item.limit
+		}
 	}
 
 	c.Lock()
 	defer c.Unlock()
-	c.items[key] = Item{
-		Value:value,
-		Created:time.Now(),
-		Expiration:expiration,
+	return c.setItem(key, item)
+}
+
+// slide renews the expiration of sliding items that were just read.
+func (c *Cache) slide(keys ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	for _, k := range keys {
+		if item, found := c.items[k]; found && item.slide > 0 && !item.expired(now) {
+			c.slideItem(k, item)
+		}
 	}
 }
 
-func (c *Cache) Get(key string) (interface{}, bool)  {
-	c.RLock()
-	defer c.RUnlock()
+// withinLimit caps the deadline at, 0 for never, at the maximum lifetime
+// of SetWithIdleTimeout items.
+func (i Item) withinLimit(at int64) int64 {
+	if i.limit > 0 && (at == 0 || at > i.limit) {
+		return i.limit
+	}
+	return at
+}
+
+// slideItem does not go through setItem: a renewed deadline is not
+// a new value, so the version stays the same.
+func (c *Cache) slideItem(key string, item Item) {
+	item.LastAccess = c.clock.Now()
+	item.Expiration = item.withinLimit(c.deadline(item.slide))
+	item.expiry = c.expiries.update(item.expiry, key, item.expiresAt())
+	c.items[key] = item
+	c.mirror(key)
+}
+
+// Pin keeps the item from expiring or being evicted, including when its
+// value is replaced later. Only Delete and the other explicit removals drop it.
+func (c *Cache) Pin(key string) bool {
+	if c.shards != nil {
+		return c.shard(key).Pin(key)
+	}
+	c.Lock()
+	defer c.Unlock()
 
 	item, found := c.items[key]
+	if !found || item.expired(c.now()) {
+		return false
+	}
+	item.Pinned = true
+	item.expiry = c.expiries.update(item.expiry, key, 0)
+	c.items[key] = item
+	c.mirror(key)
+	c.policyDelete(key, item)
+	return true
+}
+
+// Unpin makes the item subject to expiration and eviction again. An item
+// whose expiration passed while it was pinned expires right away.
+func (c *Cache) Unpin(key string) bool {
+	if c.shards != nil {
+		return c.shard(key).Unpin(key)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || !item.Pinned {
+		return false
+	}
+	item.Pinned = false
+	if item.Priority == PriorityPinned {
+		item.Priority = PriorityNormal
+	}
+	item.expiry = c.expiries.update(item.expiry, key, item.expiresAt())
+	c.items[key] = item
+	c.mirror(key)
+	c.policySet(key, item)
+	return true
+}
+
+func (c *Cache) expiration(duration time.Duration) int64 {
+	if duration == DefaultExpiration {
+		duration = c.defaultExpiration
+	}
+
+	if duration > 0 {
+		return c.deadline(duration)
+	}
+	return 0
+}
+
+// deadline returns the expiration for a positive duration, shortened
+// by a random share of up to ttlJitter so items set together expire apart.
+func (c *Cache) deadline(duration time.Duration) int64 {
+	if c.ttlJitter > 0 {
+		duration -= time.Duration(rand.Float64() * c.ttlJitter * float64(duration))
+	}
+	return c.now() + int64(duration)
+}
+
+// now is the time on the cache's own clock: nanoseconds since New. The system
+// clock reports monotonic time here, so deadlines survive wall clock steps.
+func (c *Cache) now() int64 {
+	if c.coarse != nil {
+		return c.coarse.Load()
+	}
+	return int64(c.clock.Now().Sub(c.epoch))
+}
+
+// wallTime converts a deadline on the cache clock back to a time.Time.
+func (c *Cache) wallTime(at int64) time.Time {
+	return c.epoch.Add(time.Duration(at))
+}
+
+// WithTTLJitter randomizes every expiration within the last fraction
+// of its duration: with 0.1 a 10 minute item lives between 9 and 10 minutes.
+func WithTTLJitter(fraction float64) Option {
+	return func(c *Cache) {
+		c.ttlJitter = fraction
+	}
+}
+
+func (i Item) expired(now int64) bool {
+	at := i.expiresAt()
+	return at > 0 && now > at
+}
+
+// expiresAt is the deadline that is actually enforced: pinned items keep
+// their Expiration but do not expire until they are unpinned.
+func (i Item) expiresAt() int64 {
+	if i.Pinned {
+		return 0
+	}
+	return i.Expiration
+}
+
+func (c *Cache) Get(key string) (interface{}, bool)  {
+	if c.latency != nil {
+		defer c.latency.get.since(time.Now())
+	}
+	value, _, found := c.get(key)
+	return value, found
+}
+
+func (c *Cache) get(key string) (value interface{}, stale, found bool) {
+	c.hot.record(key)
+	if c.shards != nil {
+		return c.shard(key).get(key)
+	}
+	item, found := c.lookup(key)
 
 	if !found{
-		return nil,false
+		// misses count too for policies that track how often keys are wanted
+		c.policyGet(key, item)
+		value, found = c.fromOverflow(key)
+		return c.read(value), false, c.hit(key, found)
 	}
 
-	if item.Expiration > 0{
-		if time.Now().UnixNano() > item.Expiration{
-			return nil, false
+	now := c.now()
+	if item.expired(now) {
+		if c.staleGrace > 0 && !item.expired(now-int64(c.staleGrace)) {
+			c.accessed(item)
+			c.refresh(key)
+			return c.read(item.Value), true, c.hit(key, true)
 		}
+		c.clearItems([]string{key})
+		return nil, false, c.hit(key, false)
 	}
-	return item.Value, true
+	c.policyGet(key, item)
+	c.accessed(item)
+	if item.slide > 0 {
+		c.slide(key)
+	} else if c.refreshDue(item, now) || c.xfetchDue(item, now) {
+		c.refresh(key)
+	}
+	return c.read(item.Value), false, c.hit(key, true)
+}
+
+// TTL returns the remaining lifetime of the item, or NoExpiration.
+func (c *Cache) TTL(key string) (time.Duration, bool) {
+	if c.shards != nil {
+		return c.shard(key).TTL(key)
+	}
+	now := c.now()
+	item, found := c.lookup(key)
+	if !found || item.expired(now) {
+		return 0, false
+	}
+	if item.expiresAt() == 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(item.Expiration - now), true
+}
+
+// Exists reports whether the key is present and not expired.
+func (c *Cache) Exists(key string) bool {
+	if c.shards != nil {
+		return c.shard(key).Exists(key)
+	}
+	item, found := c.lookup(key)
+
+	if found && item.expired(c.now()) {
+		c.clearItems([]string{key})
+		return false
+	}
+	return found
+}
+
+// GetWithExpiration returns the value and the moment it expires.
+// The time is zero if the item never expires.
+func (c *Cache) GetWithExpiration(key string) (interface{}, time.Time, bool) {
+	if c.shards != nil {
+		return c.shard(key).GetWithExpiration(key)
+	}
+	item, found := c.lookup(key)
+	if !found || item.expired(c.now()) {
+		return nil, time.Time{}, c.hit(key, false)
+	}
+	if item.expiresAt() > 0 {
+		return c.read(item.Value), c.wallTime(item.Expiration), c.hit(key, true)
+	}
+	return c.read(item.Value), time.Time{}, c.hit(key, true)
+}
+
+// GetWithVersion returns the value with its version for a later CompareAndSwap.
+func (c *Cache) GetWithVersion(key string) (interface{}, uint64, bool) {
+	if c.shards != nil {
+		return c.shard(key).GetWithVersion(key)
+	}
+	item, found := c.lookup(key)
+	if !found || item.expired(c.now()) {
+		return nil, 0, c.hit(key, false)
+	}
+	return c.read(item.Value), item.Version, c.hit(key, true)
+}
+
+// CompareAndSwap stores the value only if the item still has the given version.
+// Version 0 matches a missing key, so it can be used to create an item.
+// The error is why a matching item could not be stored.
+func (c *Cache) CompareAndSwap(key string, version uint64, value interface{}, duration time.Duration) (bool, error) {
+	if c.shards != nil {
+		return c.shard(key).CompareAndSwap(key, version, value, duration)
+	}
+	item := c.newItem(value, duration)
+
+	c.Lock()
+	defer c.Unlock()
+	var current uint64
+	if old, found := c.items[key]; found && !old.expired(c.now()) {
+		current = old.Version
+	}
+	if current != version {
+		return false, nil
+	}
+	if err := c.setItem(key, item); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
+// CompareAndDelete removes the item only if it still has the given version.
+func (c *Cache) CompareAndDelete(key string, version uint64) bool {
+	if c.shards != nil {
+		return c.shard(key).CompareAndDelete(key, version)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[key]
+	if !found || item.expired(c.now()) || item.Version != version {
+		return false
+	}
+	c.removeItem(key, ReasonDeleted)
+	return true
+}
+
+// GetOrSet returns the stored value, or computes it with valueFn and stores it
+// if the key is missing. valueFn runs under the write lock, so it must not use the cache.
+// The loaded result reports whether the value was already present, err why
+// the computed one could not be stored.
+func (c *Cache) GetOrSet(key string, valueFn func() interface{}, duration time.Duration) (value interface{}, loaded bool, err error) {
+	if c.shards != nil {
+		return c.shard(key).GetOrSet(key, valueFn, duration)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if item, found := c.items[key]; found && !item.expired(c.now()) {
+		c.policyGet(key, item)
+		c.accessed(item)
+		if item.slide > 0 {
+			c.slideItem(key, item)
+		}
+		return c.read(item.Value), true, nil
+	}
+	item := c.newItem(valueFn(), duration)
+	if err := c.setItem(key, item); err != nil {
+		return nil, false, err
+	}
+	return c.read(item.Value), false, nil
+}
+
+// GetAll returns the unexpired values. The lock is only held to copy the
+// entries into a slice, the map is built after releasing it. With
+// StorageSyncMap or StorageSnapshot it is not taken at all, and sharded
+// caches copy one shard at a time.
 func (c *Cache) GetAll() map[string]interface{}  {
+	if c.shards != nil {
+		return c.getAllShards()
+	}
+	entries := c.unexpired()
+
+	allItems := make(map[string]interface{}, len(entries))
+	for _, e := range entries {
+		allItems[e.key] = c.read(e.item.Value)
+	}
+	return allItems
+}
+
+// GetMany returns the unexpired values found for keys; missing keys are left out.
+func (c *Cache) GetMany(keys []string) map[string]interface{} {
+	if c.shards != nil {
+		return c.getManyShards(keys)
+	}
+	var expired, sliding []string
+
+	c.RLock()
+	now := c.now()
+	values := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		item, found := c.items[k]
+		if !found {
+			continue
+		}
+		if item.expired(now) {
+			expired = append(expired, k)
+			continue
+		}
+		c.policyGet(k, item)
+		c.accessed(item)
+		if item.slide > 0 {
+			sliding = append(sliding, k)
+		}
+		values[k] = item.Value
+	}
+	c.RUnlock()
+	for k, v := range values {
+		values[k] = c.read(v)
+	}
+	c.stats.hits.Add(uint64(len(values)))
+	c.stats.misses.Add(uint64(len(keys) - len(values)))
+	if c.nsStats != nil {
+		for _, k := range keys {
+			_, found := values[k]
+			c.namespaceCountersOf(k).read(found)
+		}
+	}
+
+	if len(expired) != 0 {
+		c.clearItems(expired)
+	}
+	if len(sliding) != 0 {
+		c.slide(sliding...)
+	}
+	return values
+}
+
+// SetMany stores all values with the same expiration under one lock.
+// It returns the errors of the keys that could not be written, like SetAll.
+func (c *Cache) SetMany(values map[string]interface{}, duration time.Duration) map[string]error {
+	if c.shards != nil {
+		return c.setManyShards(values, duration)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	var errs map[string]error
+	for k, v := range values {
+		if err := c.setItem(k, c.newItem(v, duration)); err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[k] = err
+		}
+	}
+	return errs
+}
+
+// ItemSpec describes an item written by SetAll.
+type ItemSpec struct {
+	Value    interface{}
+	Duration time.Duration // DefaultExpiration, NoExpiration or a lifetime
+	Priority Priority
+	Cost     int64 // as for SetWithCost, 0 to estimate the size
+}
+
+// SetAll writes all items under a single lock, or one per shard in sharded
+// caches. It returns the errors of the keys that could not be written.
+func (c *Cache) SetAll(items map[string]ItemSpec) map[string]error {
+	if c.shards != nil {
+		return c.setAllShards(items)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	var errs map[string]error
+	for k, spec := range items {
+		item := c.newItem(spec.Value, spec.Duration)
+		item.Priority = spec.Priority
+		item.Pinned = spec.Priority == PriorityPinned
+		item.cost = spec.Cost
+		if err := c.setItem(k, item); err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[k] = err
+		}
+	}
+	return errs
+}
+
+// DeleteMany removes keys under one lock. The result holds ErrKeyNotFound
+// at the position of every key that was not present.
+func (c *Cache) DeleteMany(keys []string) []error {
+	if c.shards != nil {
+		return c.deleteManyShards(keys)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	errs := make([]error, len(keys))
+	for i, k := range keys {
+		if _, found := c.items[k]; !found {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		c.removeItem(k, ReasonDeleted)
+	}
+	return errs
+}
+
+// ForEach calls fn for every unexpired item until it returns false. The items
+// are copied first, so fn runs without any lock held and may use the cache.
+func (c *Cache) ForEach(fn func(key string, value interface{}) bool) {
+	if c.shards != nil {
+		c.forEachShard(fn)
+		return
+	}
+	c.forEach(fn)
+}
+
+func (c *Cache) forEach(fn func(key string, value interface{}) bool) bool {
+	for _, e := range c.unexpired() {
+		if !fn(e.key, c.read(e.item.Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// unexpired returns the unexpired items, deleting the expired ones found.
+func (c *Cache) unexpired() []keyItem {
+	entries, expired := c.entries()
+	if len(expired) != 0 {
+		c.clearItems(expired)
+	}
+	return entries
+}
+
+// Keys returns the keys of all unexpired items.
+func (c *Cache) Keys() []string {
+	if c.shards != nil {
+		return c.keysOfShards()
+	}
 	c.RLock()
 	defer c.RUnlock()
-	allItems := make(map[string]interface{})
-	for k, v :=range c.items{
-		allItems[k] = v.Value
-		//fmt.Println(k, " ", v)
+
+	now := c.now()
+	keys := make([]string, 0, len(c.items))
+	for k, v := range c.items {
+		if !v.expired(now) {
+			keys = append(keys, k)
+		}
 	}
-	return allItems
+	return keys
 }
 
 func (c *Cache) Delete(key string) error{
+	if c.latency != nil {
+		defer c.latency.delete.since(time.Now())
+	}
+	if c.shards != nil {
+		return c.shard(key).Delete(key)
+	}
 	c.Lock()
 	defer c.Unlock()
 
 	if _, found := c.items[key]; !found{
-		return errors.New("Key not found")
+		c.forgetOverflow(key)
+		return ErrKeyNotFound
 	}
-	delete(c.items, key)
+	c.removeItem(key, ReasonDeleted)
 	return nil
 }
 
-func (c *Cache) Count() (count int) {
+// GetAndDelete removes the key and returns the value it held.
+func (c *Cache) GetAndDelete(key string) (interface{}, bool) {
+	if c.shards != nil {
+		return c.shard(key).GetAndDelete(key)
+	}
+	c.Lock()
+	item, found := c.items[key]
+	expired := found && item.expired(c.now())
+	if expired {
+		c.expireItem(key, item)
+	} else if found {
+		c.removeItem(key, ReasonDeleted)
+	}
+	onExpired := c.onExpired
+	c.Unlock()
+
+	if !found {
+		return nil, false
+	}
+	if expired {
+		if onExpired != nil {
+			onExpired(key, item.Value)
+		}
+		return nil, false
+	}
+	return item.Value, true
+}
+
+// Rename moves the item to a new key, overwriting whatever was stored there.
+// When the new key is refused, by the admission policy or the size limits,
+// both keys keep what they held.
+func (c *Cache) Rename(oldKey, newKey string) error {
+	if c.shards != nil {
+		return c.renameAcross(oldKey, newKey)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[oldKey]
+	if !found || item.expired(c.now()) {
+		return ErrKeyNotFound
+	}
+	if newKey == oldKey {
+		return nil
+	}
+	return c.renameItem(c, oldKey, newKey, item)
+}
+
+// renameItem moves item from oldKey in from to newKey in c, putting it and
+// the item it was to replace back when c does not store it. The caller holds
+// the write locks of both.
+func (c *Cache) renameItem(from *Cache, oldKey, newKey string, item Item) error {
+	target, found := c.items[newKey]
+	if found {
+		c.deleteItem(newKey)
+	}
+	from.deleteItem(oldKey)
+	err := c.setItem(newKey, item)
+	if _, stored := c.items[newKey]; stored {
+		if found {
+			c.replaced(newKey, target)
+		}
+		return err
+	}
+	// both had room before, so neither can be refused now
+	from.setItem(oldKey, item)
+	if found {
+		c.setItem(newKey, target)
+	}
+	return err
+}
+
+// Copy stores the value of src under dst with a new expiration.
+func (c *Cache) Copy(src, dst string, duration time.Duration) error {
+	if c.shards != nil {
+		return c.copyAcross(src, dst, duration)
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[src]
+	if !found || item.expired(c.now()) {
+		return ErrKeyNotFound
+	}
+	return c.setItem(dst, c.newItem(item.Value, duration))
+}
+
+// Flush drops all items.
+func (c *Cache) Flush() {
+	if c.shards != nil {
+		c.flushShards()
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.flush()
+	c.aof.flush()
+}
+
+// flush is Flush for the caller holding the write lock.
+func (c *Cache) flush() {
+	if c.closed {
+		return
+	}
+	if c.onEvicted != nil {
+		for k, item := range c.items {
+			c.evicted(k, item, ReasonFlushed)
+		}
+	} else {
+		c.evictions[ReasonFlushed].Add(uint64(len(c.items)))
+	}
+	c.logEvent("flush", "")
+	c.items = make(map[string]Item, c.initialCapacity)
+	c.mirrorClear()
+	c.keys = nil
+	c.expiries = nil
+	c.usedBytes.Store(0)
+	c.resetNamespaceCounters()
+	c.count.Store(0)
+	c.resetPolicy()
+}
+
+// RandomKey returns a uniformly chosen unexpired key.
+func (c *Cache) RandomKey() (string, bool) {
+	if c.shards != nil {
+		return c.randomKeyOfShards()
+	}
 	c.RLock()
 	defer c.RUnlock()
-	count = len(c.items)
-	return
+
+	if len(c.keys) == 0 {
+		return "", false
+	}
+	now := c.now()
+	for i := 0; i < randomKeyAttempts; i++ {
+		key := c.keys[rand.Intn(len(c.keys))]
+		if !c.items[key].expired(now) {
+			return key, true
+		}
+	}
+	// Mostly expired: walk from a random offset instead of sampling forever.
+	start := rand.Intn(len(c.keys))
+	for i := range c.keys {
+		key := c.keys[(start+i)%len(c.keys)]
+		if !c.items[key].expired(now) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (c *Cache) Count() (count int) {
+	if c.shards != nil {
+		return c.countShards()
+	}
+	return int(c.count.Load())
 }
 
+// setItem and deleteItem are the only places that modify c.items,
+// so the bookkeeping around the map stays consistent. Callers hold the write lock.
+func (c *Cache) setItem(key string, item Item) error {
+	if c.closed {
+		return ErrClosed
+	}
+	old, found := c.items[key]
+	logged, err := c.aof.setRecord(c, key, item, old.Pinned)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// whatever happens next, an older value must not come back
+		c.forgetOverflow(key)
+	}
+	// items fresh from newItem replace the value, the rest modify it
+	fresh := item.Version == 0
+	if found && fresh {
+		c.replaced(key, old)
+	}
+	c.version++
+	item.Version = c.version
+	if c.tooLarge(key, &item) {
+		// the key must not keep serving the value it was meant to lose
+		if found && !fresh {
+			c.evicted(key, old, ReasonCapacity)
+		}
+		if found {
+			c.deleteItem(key)
+		}
+		c.oversized.Add(1)
+		if c.skipOversized {
+			return nil
+		}
+		return ErrValueTooLarge
+	}
+	if !found && !item.Pinned {
+		if !c.admit(key, item) {
+			return nil
+		}
+		// make room first, so the new key can not be its own victim
+		ns := c.namespaceOf(key)
+		c.evict(1, item.size, ns)
+		c.evictNamespace(ns, 1, item.size)
+	}
+	switch {
+	case found && item.access == nil:
+		item.access = old.access
+	case c.trackAccess && item.access == nil:
+		item.access = new(itemAccess)
+	}
+	if found {
+		item.index = old.index
+		item.Pinned = item.Pinned || old.Pinned
+		if !old.Pinned && (item.Pinned || item.Priority != old.Priority) {
+			c.policyDelete(key, old)
+		}
+	} else {
+		item.index = len(c.keys)
+		c.keys = append(c.keys, key)
+		c.count.Add(1)
+	}
+	item.expiry = c.expiries.update(old.expiry, key, item.expiresAt())
+	c.items[key] = item
+	c.mirror(key)
+	c.usedBytes.Add(item.size - old.size)
+	ns := c.namespaceOf(key)
+	ns.add(found, item.size-old.size)
+	c.namespaceCountersOf(key).add(found, item.size-old.size)
+
+	if !item.Pinned {
+		c.policySet(key, item)
+	}
+	c.stats.sets.Add(1)
+	c.logEvent("set", key)
+	c.aof.append(logged)
+	if item.size > old.size {
+		c.evict(0, 0, ns)
+		c.evictNamespace(ns, 0, 0)
+	}
+	return nil
+}
+
+// removeItem is deleteItem telling OnEvicted why the item went away.
+func (c *Cache) removeItem(key string, reason EvictionReason) {
+	if item, found := c.items[key]; found {
+		c.evicted(key, item, reason)
+	}
+	c.deleteItem(key)
+}
+
+func (c *Cache) deleteItem(key string) {
+	c.policyDelete(key, c.items[key])
+	c.dropItem(key)
+}
+
+// dropItem is deleteItem for keys the eviction policy already forgot.
+func (c *Cache) dropItem(key string) {
+	item, found := c.items[key]
+	if !found {
+		return
+	}
+	last := len(c.keys) - 1
+	if moved := c.keys[last]; moved != key {
+		c.keys[item.index] = moved
+		m := c.items[moved]
+		m.index = item.index
+		c.items[moved] = m
+	}
+	c.keys = c.keys[:last]
+	c.usedBytes.Add(-item.size)
+	c.count.Add(-1)
+	c.namespaceOf(key).remove(item.size)
+	c.namespaceCountersOf(key).remove(item.size)
+	c.expiries.remove(item.expiry)
+	delete(c.items, key)
+	c.mirror(key)
+	c.aof.delete(key)
+}
+
+// Close stops the GC goroutine and releases all items. The cache is unusable
+// afterwards: reads find nothing and writes fail with ErrClosed.
+func (c *Cache) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	close(c.stop)
+	if c.aofPath != "" {
+		// before the shards drop their items, which a rewrite may be reading
+		c.aof.close()
+	}
+	c.closeShards()
+	if c.events != nil {
+		close(c.events)
+	}
+	c.items = nil
+	c.mirrorClear()
+	c.keys = nil
+	c.expiries = nil
+	c.usedBytes.Store(0)
+	c.resetNamespaceCounters()
+	c.count.Store(0)
+	c.resetPolicy()
+	return nil
+}
+
+// StartGC starts the cleanup goroutine unless it is already running.
 func (c *Cache) StartGC()  {
-	go c.GC()
+	c.Lock()
+	start := !c.gcRunning && !c.closed
+	c.gcRunning = true
+	c.Unlock()
+
+	if start {
+		go c.GC()
+	}
+}
+
+// SetCleanupInterval changes how often the GC runs, starting it if needed.
+// A non-positive interval stops it.
+func (c *Cache) SetCleanupInterval(d time.Duration) {
+	c.Lock()
+	c.cleanupInterval = d
+	start := d > 0 && !c.gcRunning && !c.closed
+	if start {
+		c.gcRunning = true
+	}
+	c.Unlock()
+
+	if start {
+		go c.GC()
+		return
+	}
+	select {
+	case c.reschedule <- struct{}{}:
+	default:
+	}
 }
 
+// GC runs the cleanup loop until the cache is closed. A panic in a callback
+// called from the loop is reported to the OnGCError hook and the loop restarts.
 func (c *Cache) GC()  {
+	for !c.runGC() {
+	}
+}
+
+func (c *Cache) runGC() (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.gcFailed(&PanicError{Value: r, Stack: debug.Stack()})
+		}
+	}()
+
 	for{
-		<-time.After(c.cleanupInterval)
-		fmt.Println("GC is started")
-		if c.items == nil{
-			return
+		c.Lock()
+		interval := c.cleanupInterval
+		if interval <= 0 {
+			c.gcRunning = false
+			c.Unlock()
+			return true
+		}
+		c.Unlock()
+
+		select {
+		case <-c.clock.After(interval):
+		case <-c.reschedule:
+			continue
+		case <-c.stop:
+			return true
+		case <-c.done:
+			return true
 		}
-		if keys := c.expiredKeys(); len(keys) != 0{
-			fmt.Println("We have expiredKeys: keys = ", keys)
-			c.clearItems(keys)
+		start := c.clock.Now()
+		expired, scanned := c.cleanup()
+		c.adaptInterval(expired, scanned)
+		c.compactIfSparse()
+		took := c.clock.Now().Sub(start)
+		c.gcLast.Store(int64(took))
+		c.gcCycles.Add(1)
+		c.log(LogDebug, "GC cycle finished", "expired", expired, "scanned", scanned, "duration", took)
+		c.gcCycleDone(GCCycle{Scanned: scanned, Expired: expired, Duration: took})
+	}
+}
+
+// cleanup runs one GC pass and returns how many of the scanned items expired.
+// The shards are cleaned one after the other, each under its own lock and in
+// batches of at most shardGCBatchSize, so the other shards stay writable.
+func (c *Cache) cleanup() (expired, scanned int) {
+	c.log(LogDebug, "GC started")
+	if c.shards == nil {
+		return c.expire()
+	}
+	for _, s := range c.shards {
+		e, n := s.expire()
+		expired, scanned = expired+e, scanned+n
+	}
+	return
+}
+
+func (c *Cache) expire() (expired, scanned int) {
+	if c.sampleSize > 0 {
+		keys, sampled := c.expireSampled()
+		if len(keys) != 0 {
+			c.log(LogDebug, "expired items deleted", "count", len(keys), "keys", keys)
 		}
+		return len(keys), sampled
+	}
+	keys, scanned := c.expiredKeys()
+	if len(keys) != 0{
+		c.log(LogDebug, "deleting expired items", "count", len(keys), "keys", keys)
+		c.clearExpired(keys)
+	}
+	return len(keys), scanned
+}
+
+// WithAdaptiveCleanup lets the GC tune its own interval within the bounds:
+// it runs twice as often while a quarter or more of the expiring items are
+// found expired, and half as often while less than one in twenty is.
+func WithAdaptiveCleanup(minInterval, maxInterval time.Duration) Option {
+	return func(c *Cache) {
+		c.minCleanup, c.maxCleanup = minInterval, maxInterval
+	}
+}
+
+func (c *Cache) adaptInterval(expired, scanned int) {
+	if c.maxCleanup <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	d := c.cleanupInterval
+	switch {
+	case scanned > 0 && expired*4 >= scanned:
+		d /= 2
+	case expired*20 < scanned || scanned == 0:
+		d *= 2
+	}
+	if d < c.minCleanup {
+		d = c.minCleanup
+	}
+	if d > c.maxCleanup {
+		d = c.maxCleanup
+	}
+	if d > 0 {
+		c.cleanupInterval = d
+	}
+}
+
+// PanicError is passed to the OnGCError hook when the GC recovers from a panic.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("GC panic: %v", e.Value)
+}
+
+// OnGCError registers f to be told about panics the GC recovered from.
+func (c *Cache) OnGCError(f func(err error)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onGCError = f
+}
+
+func (c *Cache) gcFailed(err error) {
+	c.RLock()
+	f := c.onGCError
+	c.RUnlock()
+
+	c.log(LogError, "GC restarted after error", "err", err)
+	if f != nil {
+		f(err)
 	}
 }
 
-func (c *Cache) expiredKeys() (keys []string){
+// expiredKeys also returns the number of items that can expire at all.
+func (c *Cache) expiredKeys() (keys []string, expiring int){
 	c.RLock()
 	defer c.RUnlock()
+	defer c.gcHolding(c.clock.Now())
 
-	for k, i := range c.items{
-		if time.Now().UnixNano() > i.Expiration && i.Expiration > 0{
-			keys = append(keys, k)
+	return c.expiries.expired(c.purgeTime()), len(c.expiries)
+}
+
+// WithGCBatchSize makes the GC delete at most n expired items per write lock,
+// pausing between batches so writers are not blocked by a large cleanup.
+func WithGCBatchSize(n int) Option {
+	return func(c *Cache) {
+		c.gcBatchSize = n
+	}
+}
+
+// WithGCYield sets how long the GC sleeps between batches and sampling rounds.
+// Without it the GC only yields the processor.
+func WithGCYield(d time.Duration) Option {
+	return func(c *Cache) {
+		c.gcYield = d
+	}
+}
+
+func (c *Cache) clearExpired(keys []string) {
+	if c.gcBatchSize <= 0 {
+		c.gcHeld.Add(int64(c.clearItems(keys)))
+		return
+	}
+	for len(keys) > 0 {
+		n := min(c.gcBatchSize, len(keys))
+		c.gcHeld.Add(int64(c.clearItems(keys[:n])))
+		keys = keys[n:]
+		if len(keys) > 0 {
+			c.gcPause()
 		}
 	}
-	return
 }
 
-func (c *Cache) clearItems(keys []string)  {
+func (c *Cache) gcPause() {
+	if c.gcYield > 0 {
+		<-c.clock.After(c.gcYield)
+		return
+	}
+	runtime.Gosched()
+}
+
+// clearItems deletes the keys that are still expired: they may have been
+// set again since they were found expired under the read lock. It returns
+// how long it held the lock.
+func (c *Cache) clearItems(keys []string) (held time.Duration) {
+	var removed []keyItem
+
 	c.Lock()
-	defer c.Unlock()
+	start := c.clock.Now()
+	now := c.purgeTime()
+	onExpired := c.onExpired
 	for _, k := range keys{
-		delete(c.items, k)
+		if item, found := c.items[k]; found && item.expired(now) {
+			if onExpired != nil {
+				removed = append(removed, keyItem{k, item})
+			}
+			c.expireItem(k, item)
+		}
+	}
+	held = c.clock.Now().Sub(start)
+	c.Unlock()
+
+	for _, r := range removed {
+		onExpired(r.key, r.item.Value)
+	}
+	return
+}
+
+type keyItem struct {
+	key  string
+	item Item
+}
+
+// OnExpired registers f to be called with every item the GC or a read
+// removes because it expired. f runs outside the lock; nil unregisters it.
+func (c *Cache) OnExpired(f func(key string, value interface{})) {
+	for _, s := range c.shards {
+		s.OnExpired(f)
 	}
+	c.Lock()
+	defer c.Unlock()
+	c.onExpired = f
 }
 
+const randomKeyAttempts = 16
+
 const N  = 10
 
 func main() {
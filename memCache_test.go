@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxLifetime(t *testing.T) {
+	clock := newTestClock()
+	c := New(0, 0, WithClock(clock))
+	c.SetWithIdleTimeout("session", 1, time.Hour, time.Minute)
+	check := func(op string) {
+		t.Helper()
+		_, expiration, found := c.GetWithExpiration("session")
+		if !found || expiration.IsZero() || expiration.Sub(clock.Now()) > time.Minute {
+			t.Errorf("after %s the session expires at %v, past its lifetime", op, expiration)
+		}
+	}
+	clock.Advance(10 * time.Second)
+	c.Touch("session", time.Hour)
+	check("Touch")
+	c.Expire("session", time.Hour)
+	check("Expire")
+	c.Persist("session")
+	check("Persist")
+	clock.Advance(time.Minute)
+	if _, found := c.Get("session"); found {
+		t.Error("session outlived its lifetime")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"time"
+)
+
+// WithMaxMemory bounds the estimated memory used by keys and values.
+// Setting items beyond it evicts items chosen by the policy.
+func WithMaxMemory(bytes int64) Option {
+	return func(c *Cache) {
+		c.maxMemory = bytes
+	}
+}
+
+// SetWithCost is Set charging cost instead of the estimated size against the
+// WithMaxMemory budget, so it can be counted in any unit. The cost sticks to
+// the item until its value is replaced by Set, Add or Replace.
+func (c *Cache) SetWithCost(key string, value interface{}, duration time.Duration, cost int64) error {
+	if c.shards != nil {
+		return c.shard(key).SetWithCost(key, value, duration, cost)
+	}
+	item := c.newItem(value, duration)
+	item.cost = cost
+
+	c.Lock()
+	defer c.Unlock()
+	return c.setItem(key, item)
+}
+
+// WithMaxValueSize makes writes of values estimated larger than bytes fail
+// with ErrValueTooLarge, rather than evicting a large part of the cache.
+// The same applies to items larger than the whole WithMaxMemory budget.
+func WithMaxValueSize(bytes int64) Option {
+	return func(c *Cache) {
+		c.maxValueSize = bytes
+	}
+}
+
+// WithSkipOversized makes writes of values that are too large succeed
+// without storing anything instead of returning ErrValueTooLarge.
+func WithSkipOversized() Option {
+	return func(c *Cache) {
+		c.skipOversized = true
+	}
+}
+
+// tooLarge sizes the item for the budget and reports whether it can not
+// be stored at all.
+func (c *Cache) tooLarge(key string, item *Item) bool {
+	if c.maxValueSize > 0 && sizeOf(item.Value) > c.maxValueSize {
+		return true
+	}
+	ns := c.namespaceOf(key)
+	if c.maxMemory <= 0 && c.nsStats == nil && (ns == nil || ns.maxBytes <= 0) {
+		return false
+	}
+	item.size = item.cost
+	if item.size <= 0 {
+		item.size = int64(len(key)) + sizeOf(item.Value)
+	}
+	return (c.maxMemory > 0 && item.size > c.maxMemory) ||
+		(ns != nil && ns.maxBytes > 0 && item.size > ns.maxBytes)
+}
+
+// Sizer can be implemented by values that know their size better than the
+// reflection based estimate used with WithMaxMemory.
+type Sizer interface {
+	Size() int64
+}
+
+func sizeOf(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case Sizer:
+		return v.Size()
+	case string:
+		return int64(16 + len(v))
+	case []byte:
+		return int64(24 + cap(v))
+	}
+	return estimateSize(reflect.ValueOf(v), make(map[uintptr]bool))
+}
+
+// estimateSize adds up the memory directly held by v and everything it
+// references. Shared pointers are only counted once.
+func estimateSize(v reflect.Value, seen map[uintptr]bool) int64 {
+	return int64(v.Type().Size()) + referencedSize(v, seen)
+}
+
+func referencedSize(v reflect.Value, seen map[uintptr]bool) int64 {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		return estimateSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return estimateSize(v.Elem(), seen)
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		size := int64(v.Type().Elem().Size()) * int64(v.Cap())
+		if !flat(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += referencedSize(v.Index(i), seen)
+			}
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		if !flat(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += referencedSize(v.Index(i), seen)
+			}
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		var size int64
+		for it := v.MapRange(); it.Next(); {
+			size += estimateSize(it.Key(), seen) + estimateSize(it.Value(), seen)
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += referencedSize(v.Field(i), seen)
+		}
+		return size
+	}
+	return 0
+}
+
+// flat reports whether values of t reference no other memory.
+func flat(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return flat(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !flat(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTooLargeReported(t *testing.T) {
+	for _, shards := range []int{0, 2} {
+		c := New(0, 0, WithShards(shards), WithMaxValueSize(64))
+		big := strings.Repeat("x", 1000)
+
+		if _, _, err := c.Swap("a", big, NoExpiration); err != ErrValueTooLarge {
+			t.Errorf("Swap: %v", err)
+		}
+		if swapped, err := c.CompareAndSwap("a", 0, big, NoExpiration); swapped || err != ErrValueTooLarge {
+			t.Errorf("CompareAndSwap: %v, %v", swapped, err)
+		}
+		if _, loaded, err := c.GetOrSet("a", func() interface{} { return big }, NoExpiration); loaded || err != ErrValueTooLarge {
+			t.Errorf("GetOrSet: %v, %v", loaded, err)
+		}
+		errs := c.SetMany(map[string]interface{}{"a": big, "b": 1}, NoExpiration)
+		if len(errs) != 1 || errs["a"] != ErrValueTooLarge {
+			t.Errorf("SetMany: %v", errs)
+		}
+		if _, found := c.Get("a"); found {
+			t.Error("too large value stored")
+		}
+		c.Close()
+		if err := c.Set("b", 1, NoExpiration); err != ErrClosed {
+			t.Errorf("Set after Close: %v", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "strings"
+
+const defaultNamespaceSeparator = ":"
+
+// WithNamespaceQuota bounds the keys of a namespace, the part of the key
+// before the first separator, to maxItems and maxBytes; 0 leaves either
+// unbounded. Its keys are evicted by a policy of their own, so filling one
+// namespace never evicts the keys of another, short of reaching the limits
+// of the whole cache with no key of its own left to give up.
+func WithNamespaceQuota(name string, maxItems int, maxBytes int64) Option {
+	return func(c *Cache) {
+		if c.namespaces == nil {
+			c.namespaces = make(map[string]*namespace)
+		}
+		c.namespaces[name] = &namespace{maxItems: maxItems, maxBytes: maxBytes}
+	}
+}
+
+// WithNamespaceSeparator sets what ends the namespace of a key, ":" by default.
+func WithNamespaceSeparator(sep string) Option {
+	return func(c *Cache) {
+		c.namespaceSeparator = sep
+	}
+}
+
+// namespace tracks the usage of a namespace with a quota. Its methods
+// accept nil, the namespace of keys without a quota.
+type namespace struct {
+	maxItems  int
+	maxBytes  int64
+	items     int
+	usedBytes int64
+	policies  [numPriorities]EvictionPolicy
+}
+
+// namespaceOf returns the namespace of the key if it has a quota.
+func (c *Cache) namespaceOf(key string) *namespace {
+	if len(c.namespaces) == 0 {
+		return nil
+	}
+	name, _, found := strings.Cut(key, c.namespaceSeparator)
+	if !found {
+		return nil
+	}
+	return c.namespaces[name]
+}
+
+func (ns *namespace) add(replaced bool, bytes int64) {
+	if ns == nil {
+		return
+	}
+	if !replaced {
+		ns.items++
+	}
+	ns.usedBytes += bytes
+}
+
+func (ns *namespace) remove(bytes int64) {
+	if ns != nil {
+		ns.items--
+		ns.usedBytes -= bytes
+	}
+}
+
+func (ns *namespace) overLimit(n int, bytes int64) bool {
+	return ns != nil && ((ns.maxItems > 0 && ns.items+n > ns.maxItems) ||
+		(ns.maxBytes > 0 && ns.usedBytes+bytes > ns.maxBytes))
+}
+
+func (ns *namespace) overWatermark(n int, bytes int64, fraction float64) bool {
+	if fraction <= 0 {
+		return ns.overLimit(n, bytes)
+	}
+	items := int(float64(ns.maxItems) * fraction)
+	memory := int64(float64(ns.maxBytes) * fraction)
+	return (ns.maxItems > 0 && ns.items+n > items) ||
+		(ns.maxBytes > 0 && ns.usedBytes+bytes > memory)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNamespaceEvictsItsOwn(t *testing.T) {
+	c := New(0, 0, WithMaxItems(4), WithNamespaceQuota("a", 10, 0), WithNamespaceQuota("b", 10, 0))
+	c.Set("b:1", 1, NoExpiration)
+	c.Set("b:2", 2, NoExpiration)
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint("a:", i), i, NoExpiration)
+	}
+	if !c.Exists("b:1") || !c.Exists("b:2") {
+		t.Errorf("writes to a evicted b: %v", c.Keys())
+	}
+	if !c.Exists("a:9") {
+		t.Error("last key of a evicted")
+	}
+	if n := c.Count(); n > 4 {
+		t.Errorf("count = %d, over the limit", n)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// WithNamespaceStats counts reads and usage per namespace, the part of the
+// key before the first separator, for NamespaceStats. Item sizes are then
+// estimated even without WithMaxMemory.
+func WithNamespaceStats() Option {
+	return func(c *Cache) {
+		c.nsStats = new(sync.Map)
+	}
+}
+
+// NamespaceStats is the share of a namespace in the cache.
+type NamespaceStats struct {
+	Hits      uint64
+	Misses    uint64
+	Items     int64
+	UsedBytes int64 // estimated
+}
+
+type namespaceCounters struct {
+	hits, misses     atomic.Uint64
+	items, usedBytes atomic.Int64
+}
+
+// NamespaceStats returns the counters of every namespace seen, or nil
+// unless the cache was created with WithNamespaceStats.
+func (c *Cache) NamespaceStats() map[string]NamespaceStats {
+	if c.nsStats == nil {
+		return nil
+	}
+	stats := make(map[string]NamespaceStats)
+	caches := c.shards
+	if caches == nil {
+		caches = []*Cache{c}
+	}
+	for _, s := range caches {
+		s.nsStats.Range(func(name, v interface{}) bool {
+			nc := v.(*namespaceCounters)
+			ns := stats[name.(string)]
+			ns.Hits += nc.hits.Load()
+			ns.Misses += nc.misses.Load()
+			ns.Items += nc.items.Load()
+			ns.UsedBytes += nc.usedBytes.Load()
+			stats[name.(string)] = ns
+			return true
+		})
+	}
+	return stats
+}
+
+// namespaceCountersOf returns the counters of the key's namespace, nil
+// without WithNamespaceStats or if the key has no namespace.
+func (c *Cache) namespaceCountersOf(key string) *namespaceCounters {
+	if c.nsStats == nil {
+		return nil
+	}
+	name, _, found := strings.Cut(key, c.namespaceSeparator)
+	if !found {
+		return nil
+	}
+	if v, ok := c.nsStats.Load(name); ok {
+		return v.(*namespaceCounters)
+	}
+	v, _ := c.nsStats.LoadOrStore(name, new(namespaceCounters))
+	return v.(*namespaceCounters)
+}
+
+func (nc *namespaceCounters) read(found bool) {
+	switch {
+	case nc == nil:
+	case found:
+		nc.hits.Add(1)
+	default:
+		nc.misses.Add(1)
+	}
+}
+
+func (nc *namespaceCounters) add(replaced bool, bytes int64) {
+	if nc == nil {
+		return
+	}
+	if !replaced {
+		nc.items.Add(1)
+	}
+	nc.usedBytes.Add(bytes)
+}
+
+func (nc *namespaceCounters) remove(bytes int64) {
+	if nc != nil {
+		nc.items.Add(-1)
+		nc.usedBytes.Add(-bytes)
+	}
+}
+
+// resetNamespaceCounters forgets the usage of all namespaces, keeping the
+// read counters. The caller holds the write lock.
+func (c *Cache) resetNamespaceCounters() {
+	if c.nsStats == nil {
+		return
+	}
+	c.nsStats.Range(func(_, v interface{}) bool {
+		nc := v.(*namespaceCounters)
+		nc.items.Store(0)
+		nc.usedBytes.Store(0)
+		return true
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import "time"
+
+// OverflowStore is a second tier, such as a disk or a remote cache, that
+// receives the items evicted for capacity instead of them being dropped.
+type OverflowStore interface {
+	// Put stores an evicted item. The zero expiration means never.
+	Put(key string, value interface{}, expiration time.Time)
+	// Get returns an item previously put and not deleted since.
+	Get(key string) (value interface{}, expiration time.Time, found bool)
+	Delete(key string)
+}
+
+// WithOverflowStore hands the items evicted for capacity to store. A Get
+// missing the cache looks the key up in store and moves it back; Delete and
+// writes of new keys drop it from store. Other reads only see the cache.
+// The store is called outside the lock, after the operation that evicted.
+func WithOverflowStore(store OverflowStore) Option {
+	return func(c *Cache) {
+		c.overflow = store
+	}
+}
+
+// forgetOverflow queues the key for deletion from the overflow store.
+// The caller holds the write lock.
+func (c *Cache) forgetOverflow(key string) {
+	if c.overflow != nil {
+		c.pendingEvictions = append(c.pendingEvictions, eviction{key: key, forget: true})
+	}
+}
+
+// fromOverflow moves an item missing from the cache back from the store.
+func (c *Cache) fromOverflow(key string) (interface{}, bool) {
+	if c.overflow == nil {
+		return nil, false
+	}
+	value, expiration, found := c.overflow.Get(key)
+	if !found || (!expiration.IsZero() && !c.clock.Now().Before(expiration)) {
+		return nil, false
+	}
+	item := c.newItem(value, NoExpiration)
+	if !expiration.IsZero() {
+		item.Expiration = int64(expiration.Sub(c.epoch))
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	cur, found := c.items[key]
+	if found && !cur.expired(c.now()) {
+		// set meanwhile, the store had an older value
+		return cur.Value, true
+	}
+	if found {
+		// setItem only forgets new keys
+		c.forgetOverflow(key)
+	}
+	c.setItem(key, item)
+	return value, true
+}
+
+// expirationTime is wallTime for deadlines that may be 0, never.
+func (c *Cache) expirationTime(at int64) time.Time {
+	if at == 0 {
+		return time.Time{}
+	}
+	return c.wallTime(at)
+}
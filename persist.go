@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"time"
+)
+
+// savedItem is an item as written by Save. TTL is the lifetime left
+// when the item was saved, 0 if it never expires.
+type savedItem struct {
+	Key      string
+	Value    interface{}
+	Created  time.Time
+	TTL      time.Duration
+	Priority Priority
+	Pinned   bool
+}
+
+// SaveFile writes Save of the cache to path.
+func (c *Cache) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadFile adds the items saved by SaveFile to the cache, like Load.
+func (c *Cache) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
+
+// Save streams the unexpired items to w with encoding/gob, one at a time.
+// Only the entries of one shard are copied at once, the encoding runs
+// without the lock held. The concrete types of the values must be
+// registered with gob.Register.
+func (c *Cache) Save(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	return c.eachSaved(func(item savedItem) error {
+		return enc.Encode(&item)
+	})
+}
+
+// Load adds the items streamed by Save from r, each with the lifetime it
+// had left when saved, until r ends.
+func (c *Cache) Load(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	for {
+		var item savedItem
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.restore(item); err != nil {
+			return err
+		}
+	}
+}
+
+// eachSaved calls fn with every unexpired item as it is saved, shard by
+// shard, until fn fails.
+func (c *Cache) eachSaved(fn func(item savedItem) error) error {
+	caches := c.shards
+	if caches == nil {
+		caches = []*Cache{c}
+	}
+	for _, s := range caches {
+		now := s.now()
+		for _, e := range s.unexpired() {
+			if err := fn(savedItemOf(e, now)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func savedItemOf(e keyItem, now int64) savedItem {
+	item := savedItem{
+		Key:      e.key,
+		Value:    e.item.Value,
+		Created:  e.item.Created,
+		Priority: e.item.Priority,
+		Pinned:   e.item.Pinned,
+	}
+	if e.item.Expiration > 0 {
+		item.TTL = time.Duration(max(e.item.Expiration-now, 1))
+	}
+	return item
+}
+
+// restore stores a saved item unless its TTL ran out.
+func (c *Cache) restore(saved savedItem) error {
+	if c.shards != nil {
+		return c.shard(saved.Key).restore(saved)
+	}
+	duration := saved.TTL
+	if duration <= 0 {
+		duration = NoExpiration
+	}
+	item := c.newItem(saved.Value, duration)
+	item.Created = saved.Created
+	item.Priority = saved.Priority
+	item.Pinned = saved.Pinned
+
+	c.Lock()
+	defer c.Unlock()
+	return c.setItem(saved.Key, item)
+}
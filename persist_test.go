@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type savedPoint struct {
+	X, Y int
+}
+
+func init() {
+	gob.Register(savedPoint{})
+	gob.Register([]int{})
+}
+
+// fillSaved stores items with every kind of lifetime a save has to keep,
+// a minute before the save.
+func fillSaved(c *Cache, clock *testClock) {
+	c.Set("int", 1, NoExpiration)
+	c.Set("string", "two", time.Hour)
+	c.Set("struct", savedPoint{3, 4}, NoExpiration)
+	c.Set("slice", []int{5, 6}, NoExpiration)
+	c.SetWithPriority("low", 7, NoExpiration, PriorityLow)
+	c.Set("expired", 8, time.Second)
+	clock.Advance(time.Minute)
+}
+
+func checkRestored(t *testing.T, c *Cache, clock *testClock, typed bool) {
+	t.Helper()
+	if n := c.Count(); n != 5 {
+		t.Fatalf("count = %d, want 5: %v", n, c.GetAll())
+	}
+	if _, found := c.Get("expired"); found {
+		t.Error("expired item restored")
+	}
+	_, expiration, _ := c.GetWithExpiration("string")
+	if left := expiration.Sub(clock.Now()); left != 59*time.Minute {
+		t.Errorf("string expires in %v, want what was left of its hour", left)
+	}
+	if _, expiration, _ := c.GetWithExpiration("int"); !expiration.IsZero() {
+		t.Errorf("int expires at %v, want never", expiration)
+	}
+	if !typed {
+		return
+	}
+	if v, _ := c.Get("struct"); v != (savedPoint{3, 4}) {
+		t.Errorf("struct = %v", v)
+	}
+	if v, _ := c.Get("slice"); len(v.([]int)) != 2 {
+		t.Errorf("slice = %v", v)
+	}
+	if info, _ := c.GetItem("low"); info.Priority != PriorityLow {
+		t.Errorf("low has priority %v", info.Priority)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	for _, from := range []int{0, 4} {
+		for _, to := range []int{0, 3} {
+			clock := newTestClock()
+			src := New(0, 0, WithShards(from), WithClock(clock))
+			fillSaved(src, clock)
+			var buf bytes.Buffer
+			if err := src.Save(&buf); err != nil {
+				t.Fatal(err)
+			}
+			dst := New(0, 0, WithShards(to), WithClock(clock))
+			if err := dst.Load(&buf); err != nil {
+				t.Fatal(err)
+			}
+			checkRestored(t, dst, clock, true)
+		}
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	if err := New(0, 0).Load(bytes.NewReader([]byte("not gob"))); err == nil {
+		t.Error("loaded garbage")
+	}
+}
+
+func TestSaveFileLoadFile(t *testing.T) {
+	clock := newTestClock()
+	path := filepath.Join(t.TempDir(), "cache.gob")
+	src := New(0, 0, WithClock(clock))
+	fillSaved(src, clock)
+	if err := src.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	dst := New(0, 0, WithClock(clock))
+	if err := dst.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, dst, clock, true)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// pressureEvictRatio is the share of the items, one in so many, evicted
+// every check that finds the heap above the threshold.
+const pressureEvictRatio = 10
+
+// WithMemoryPressure starts a watcher that checks the heap every interval
+// and evicts items chosen by the policy while it is above heapBytes. With
+// heapBytes 0 the threshold is 90% of the limit set by debug.SetMemoryLimit,
+// if any. Evicting again waits for a garbage collection to free the memory.
+func WithMemoryPressure(heapBytes uint64, interval time.Duration) Option {
+	return func(c *Cache) {
+		if heapBytes == 0 {
+			if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+				heapBytes = uint64(limit) / 10 * 9
+			}
+		}
+		c.pressureLimit = heapBytes
+		c.pressureInterval = interval
+	}
+}
+
+func (c *Cache) watchPressure() {
+	var stats runtime.MemStats
+	lastGC := uint32(math.MaxUint32)
+	for {
+		select {
+		case <-c.clock.After(c.pressureInterval):
+		case <-c.stop:
+			return
+		case <-c.done:
+			return
+		}
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc <= c.pressureLimit || stats.NumGC == lastGC {
+			continue
+		}
+		lastGC = stats.NumGC
+		c.EvictN(c.Count()/pressureEvictRatio + 1)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "time"
+
+// Priority ranks items for capacity eviction: victims are taken from a
+// lower class as long as it has any, each class ordered by the policy.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota - 1 // cheap to recompute, evicted first
+	PriorityNormal                     // the default
+	PriorityHigh                       // expensive to recompute, evicted last
+	PriorityPinned                     // never evicted nor expired, see Pin
+)
+
+const numPriorities = 3 // classes with a policy, PriorityPinned has none
+
+func (p Priority) class() int {
+	return min(max(int(p-PriorityLow), 0), numPriorities-1)
+}
+
+// SetWithPriority is Set for an item of the given class. Like the cost, the
+// priority sticks until the value is replaced by Set, Add or Replace.
+func (c *Cache) SetWithPriority(key string, value interface{}, duration time.Duration, p Priority) error {
+	if c.shards != nil {
+		return c.shard(key).SetWithPriority(key, value, duration, p)
+	}
+	item := c.newItem(value, duration)
+	item.Priority = p
+	item.Pinned = p == PriorityPinned
+
+	c.Lock()
+	defer c.Unlock()
+	return c.setItem(key, item)
+}
+
+// policyOf returns the policy of the item's class, in the key's namespace
+// if it has a quota. The caller holds policyMu.
+func (c *Cache) policyOf(key string, item Item) EvictionPolicy {
+	policies, capacity := &c.policies, c.maxItems
+	if ns := c.namespaceOf(key); ns != nil {
+		policies, capacity = &ns.policies, ns.maxItems
+	}
+	if capacity <= 0 {
+		capacity = defaultPolicyCapacity
+	}
+	class := item.Priority.class()
+	if policies[class] == nil {
+		policies[class] = c.newPolicy(capacity)
+	}
+	return policies[class]
+}
+
+// victim asks the lowest class holding any items. Keys in namespaces with a
+// quota are only taken from their namespace, or once no other key is left,
+// from the namespace of the writer before any other. The caller holds
+// policyMu.
+func (c *Cache) victim(ns, writer *namespace) (string, bool) {
+	if ns != nil {
+		return victimOf(&ns.policies)
+	}
+	if key, ok := victimOf(&c.policies); ok {
+		return key, true
+	}
+	if writer != nil {
+		if key, ok := victimOf(&writer.policies); ok {
+			return key, true
+		}
+	}
+	for _, ns := range c.namespaces {
+		if key, ok := victimOf(&ns.policies); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func victimOf(policies *[numPriorities]EvictionPolicy) (string, bool) {
+	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+		if key, ok := p.Victim(); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
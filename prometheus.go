@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WritePrometheus writes the metrics of the caches in the Prometheus text
+// format, each labeled with its name in caches as cache="name".
+//
+// The cache is a single package without a module or dependencies, so it can
+// neither import client_golang to implement prometheus.Collector nor hold a
+// subpackage for one. The text format is what a Collector would produce and
+// is scraped as it is; a program that already uses client_golang can serve
+// PrometheusHandler next to its registry.
+func WritePrometheus(w io.Writer, caches map[string]*Cache) error {
+	names := make([]string, 0, len(caches))
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type sample struct {
+		suffix, labels string
+		value          float64
+	}
+	type family struct {
+		name, kind, help string
+		samples          []sample
+	}
+	families := []*family{
+		{name: "memcache_hits_total", kind: "counter", help: "Reads that found a value."},
+		{name: "memcache_misses_total", kind: "counter", help: "Reads that found no value."},
+		{name: "memcache_hit_ratio", kind: "gauge", help: "Share of reads that found a value."},
+		{name: "memcache_items", kind: "gauge", help: "Items stored."},
+		{name: "memcache_used_bytes", kind: "gauge", help: "Estimated size of the items, with WithMaxMemory."},
+		{name: "memcache_evictions_total", kind: "counter", help: "Items removed, by reason."},
+		{name: "memcache_gc_cycles_total", kind: "counter", help: "Cleanup cycles run."},
+		{name: "memcache_gc_duration_seconds", kind: "gauge", help: "Duration of the last cleanup cycle."},
+		{name: "memcache_operation_duration_seconds", kind: "histogram", help: "Latency of Get, Set and Delete, with WithLatencyHistograms."},
+	}
+	for _, name := range names {
+		c := caches[name]
+		label := `cache="` + escapeLabel(name) + `"`
+		stats, capacity := c.Stats(), c.CapacityStats()
+		cycles, last := c.gcCycleStats()
+		values := []float64{
+			float64(stats.Hits),
+			float64(stats.Misses),
+			stats.HitRatio(),
+			float64(capacity.Items),
+			float64(capacity.UsedBytes),
+		}
+		for i, v := range values {
+			families[i].samples = append(families[i].samples, sample{"", label, v})
+		}
+		for reason := EvictionReason(0); reason < numReasons; reason++ {
+			labels := label + `,reason="` + reason.String() + `"`
+			families[5].samples = append(families[5].samples, sample{"", labels, float64(capacity.Evictions[reason])})
+		}
+		families[6].samples = append(families[6].samples, sample{"", label, float64(cycles)})
+		families[7].samples = append(families[7].samples, sample{"", label, last.Seconds()})
+
+		latencies := c.Latencies()
+		for _, op := range []string{"get", "set", "delete"} {
+			h, found := latencies[op]
+			if !found {
+				continue
+			}
+			labels := label + `,op="` + op + `"`
+			var cumulative uint64
+			for i, n := range h.Counts {
+				cumulative += n
+				le := "+Inf"
+				if i < len(h.Bounds) {
+					le = fmt.Sprint(h.Bounds[i].Seconds())
+				}
+				families[8].samples = append(families[8].samples, sample{"_bucket", labels + `,le="` + le + `"`, float64(cumulative)})
+			}
+			families[8].samples = append(families[8].samples,
+				sample{"_sum", labels, h.Sum.Seconds()},
+				sample{"_count", labels, float64(h.Count)})
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(bw, "%s%s{%s} %g\n", f.name, s.suffix, s.labels, s.value)
+		}
+	}
+	return bw.Flush()
+}
+
+// PrometheusHandler serves WritePrometheus of the caches, to be scraped
+// by Prometheus.
+func PrometheusHandler(caches map[string]*Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, caches)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// gcCycleStats returns how many cleanup cycles ran and how long the last took.
+func (c *Cache) gcCycleStats() (cycles uint64, last time.Duration) {
+	return c.gcCycles.Load(), time.Duration(c.gcLast.Load())
+}
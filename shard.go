@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"hash/maphash"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// shardGCBatchSize bounds the expired items a shard deletes per write lock
+// unless WithGCBatchSize sets another size.
+const shardGCBatchSize = 256
+
+// WithShards splits the storage into n shards selected by a hash of the key,
+// each with its own lock, so writes of different keys rarely contend. n <= 0
+// picks 4 * GOMAXPROCS. Limits such as WithMaxItems are divided among the
+// shards and the policies only see the keys of their shard, so eviction
+// order is approximate. Operations on several keys are atomic per shard.
+func WithShards(n int) Option {
+	return func(c *Cache) {
+		if n <= 0 {
+			n = 4 * runtime.GOMAXPROCS(0)
+		}
+		c.shardCount = n
+	}
+}
+
+// WithHasher replaces the hash of keys that selects their shard and key lock,
+// maphash with a random seed by default. It must be safe for concurrent use.
+func WithHasher(hash func(key string) uint64) Option {
+	return func(c *Cache) {
+		c.hasher = hash
+	}
+}
+
+// WithAutoTune picks the shard count and initial capacity from GOMAXPROCS
+// and the number of items expected, 0 if unknown, unless WithShards or
+// WithInitialCapacity set them. Shards only pay off once several goroutines
+// contend for the lock, so single core machines and small caches get none,
+// and each shard is given at least autoTuneShardItems of the expected items.
+func WithAutoTune(expectedItems int) Option {
+	return func(c *Cache) {
+		c.autoTune = true
+		c.expectedItems = expectedItems
+	}
+}
+
+// autoTuneShardItems is the fewest expected items per shard WithAutoTune accepts.
+const autoTuneShardItems = 1024
+
+// tune applies WithAutoTune once all other options are known.
+func (c *Cache) tune() {
+	if c.initialCapacity == 0 {
+		c.initialCapacity = c.expectedItems
+	}
+	if c.shardCount > 0 {
+		return
+	}
+	n := 1
+	for n < 4*runtime.GOMAXPROCS(0) {
+		n <<= 1
+	}
+	if c.expectedItems > 0 {
+		n = min(n, c.expectedItems/autoTuneShardItems)
+	}
+	if runtime.GOMAXPROCS(0) > 1 && n > 1 {
+		c.shardCount = n
+	}
+}
+
+// newShards creates unsharded caches with the same options and epoch.
+// The GC of the parent cleans them, so they do not run one of their own.
+func (c *Cache) newShards(defaultExpiration time.Duration, opts []Option) {
+	n := c.shardCount
+	opts = append(opts[:len(opts):len(opts)], asShard(n))
+	c.shards = make([]*Cache, n)
+	for i := range c.shards {
+		s := NewWithContext(context.Background(), defaultExpiration, 0, opts...)
+		s.epoch = c.epoch
+		s.coarse = c.coarse
+		c.shards[i] = s
+	}
+}
+
+// asShard gives a shard its part of the limits.
+func asShard(n int) Option {
+	return func(s *Cache) {
+		if s.autoTune && s.initialCapacity == 0 {
+			s.initialCapacity = s.expectedItems
+		}
+		s.shardCount = 0
+		s.autoTune = false
+		s.pressureInterval = 0
+		s.coarseResolution = 0
+		s.hot = nil
+		s.latency = nil
+		s.sizeInterval = 0
+		s.aofPath = ""
+		s.snapshotInterval = 0
+		if s.gcBatchSize <= 0 {
+			s.gcBatchSize = shardGCBatchSize
+		}
+		s.maxItems = ceilDiv(s.maxItems, n)
+		s.maxMemory = ceilDiv(s.maxMemory, int64(n))
+		s.initialCapacity = ceilDiv(s.initialCapacity, n)
+		for _, ns := range s.namespaces {
+			ns.maxItems = ceilDiv(ns.maxItems, n)
+			ns.maxBytes = ceilDiv(ns.maxBytes, int64(n))
+		}
+	}
+}
+
+func ceilDiv[T int | int64](a, b T) T {
+	return (a + b - 1) / b
+}
+
+func (c *Cache) shardIndex(key string) int {
+	return int(c.hash(key) % uint64(len(c.shards)))
+}
+
+func (c *Cache) hash(key string) uint64 {
+	if c.hasher != nil {
+		return c.hasher(key)
+	}
+	return maphash.String(c.seed, key)
+}
+
+func (c *Cache) shard(key string) *Cache {
+	return c.shards[c.shardIndex(key)]
+}
+
+// closeShards closes the shards once they no longer send to the shared
+// Expirations channel. The caller holds the write lock.
+func (c *Cache) closeShards() {
+	for _, s := range c.shards {
+		s.Lock()
+		s.events = nil
+		s.Unlock()
+		s.Close()
+	}
+}
+
+// renameAcross is Rename for keys that may live in different shards.
+func (c *Cache) renameAcross(oldKey, newKey string) error {
+	i, j := c.shardIndex(oldKey), c.shardIndex(newKey)
+	if i == j {
+		return c.shards[i].Rename(oldKey, newKey)
+	}
+	from, to := c.shards[i], c.shards[j]
+	// lock in shard order, so concurrent renames can not deadlock
+	first, second := from, to
+	if j < i {
+		first, second = to, from
+	}
+	first.Lock()
+	second.Lock()
+	defer unlockPair(first, second)
+
+	item, found := from.items[oldKey]
+	if !found || item.expired(from.now()) {
+		return ErrKeyNotFound
+	}
+	return to.renameItem(from, oldKey, newKey, item)
+}
+
+// unlockPair unlocks both shards, running the callbacks of either only once
+// neither is locked anymore.
+func unlockPair(first, second *Cache) {
+	first.pendingEvictions = append(first.pendingEvictions, second.pendingEvictions...)
+	second.pendingEvictions = nil
+	second.Unlock()
+	first.Unlock()
+}
+
+// flushShards flushes all shards at once, so the append log records a
+// single flush that no write to any shard can come between.
+func (c *Cache) flushShards() {
+	for _, s := range c.shards {
+		s.Lock()
+	}
+	for _, s := range c.shards {
+		s.flush()
+	}
+	c.aof.flush()
+	// the callbacks run once no shard is locked anymore, as in unlockPair
+	first := c.shards[0]
+	for i := len(c.shards) - 1; i > 0; i-- {
+		s := c.shards[i]
+		first.pendingEvictions = append(first.pendingEvictions, s.pendingEvictions...)
+		s.pendingEvictions = nil
+		s.Unlock()
+	}
+	first.Unlock()
+}
+
+// copyAcross is Copy for keys that may live in different shards.
+func (c *Cache) copyAcross(src, dst string, duration time.Duration) error {
+	i, j := c.shardIndex(src), c.shardIndex(dst)
+	if i == j {
+		return c.shards[i].Copy(src, dst, duration)
+	}
+	from := c.shards[i]
+	from.RLock()
+	item, found := from.items[src]
+	expired := found && item.expired(from.now())
+	from.RUnlock()
+
+	if !found || expired {
+		return ErrKeyNotFound
+	}
+	return c.shards[j].Set(dst, item.Value, duration)
+}
+
+func (c *Cache) getAllShards() map[string]interface{} {
+	parts := eachShard(c, (*Cache).GetAll)
+	n := 0
+	for _, part := range parts {
+		n += len(part)
+	}
+	values := make(map[string]interface{}, n)
+	for _, part := range parts {
+		for k, v := range part {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func (c *Cache) getManyShards(keys []string) map[string]interface{} {
+	groups := make(map[int][]string)
+	for _, k := range keys {
+		i := c.shardIndex(k)
+		groups[i] = append(groups[i], k)
+	}
+	values := make(map[string]interface{}, len(keys))
+	for i, group := range groups {
+		for k, v := range c.shards[i].GetMany(group) {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func (c *Cache) setManyShards(values map[string]interface{}, duration time.Duration) map[string]error {
+	groups := make(map[int]map[string]interface{})
+	for k, v := range values {
+		i := c.shardIndex(k)
+		if groups[i] == nil {
+			groups[i] = make(map[string]interface{})
+		}
+		groups[i][k] = v
+	}
+	var errs map[string]error
+	for i, group := range groups {
+		for k, err := range c.shards[i].SetMany(group, duration) {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[k] = err
+		}
+	}
+	return errs
+}
+
+func (c *Cache) setAllShards(items map[string]ItemSpec) map[string]error {
+	groups := make(map[int]map[string]ItemSpec)
+	for k, spec := range items {
+		i := c.shardIndex(k)
+		if groups[i] == nil {
+			groups[i] = make(map[string]ItemSpec)
+		}
+		groups[i][k] = spec
+	}
+	var errs map[string]error
+	for i, group := range groups {
+		for k, err := range c.shards[i].SetAll(group) {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[k] = err
+		}
+	}
+	return errs
+}
+
+func (c *Cache) deleteManyShards(keys []string) []error {
+	groups := make(map[int][]int) // positions in keys by shard
+	for n, k := range keys {
+		i := c.shardIndex(k)
+		groups[i] = append(groups[i], n)
+	}
+	errs := make([]error, len(keys))
+	for i, positions := range groups {
+		group := make([]string, len(positions))
+		for n, p := range positions {
+			group[n] = keys[p]
+		}
+		for n, err := range c.shards[i].DeleteMany(group) {
+			errs[positions[n]] = err
+		}
+	}
+	return errs
+}
+
+func (c *Cache) keysOfShards() []string {
+	var keys []string
+	for _, part := range eachShard(c, (*Cache).Keys) {
+		keys = append(keys, part...)
+	}
+	return keys
+}
+
+func (c *Cache) forEachShard(fn func(key string, value interface{}) bool) {
+	if !c.parallelScan {
+		for _, s := range c.shards {
+			if !s.forEach(fn) {
+				return
+			}
+		}
+		return
+	}
+	for _, entries := range eachShard(c, (*Cache).unexpired) {
+		for _, e := range entries {
+			if !fn(e.key, c.read(e.item.Value)) {
+				return
+			}
+		}
+	}
+}
+
+// WithParallelScan makes GetAll, Keys and ForEach of sharded caches copy
+// all shards at once, each in its own goroutine, instead of one by one.
+func WithParallelScan() Option {
+	return func(c *Cache) {
+		c.parallelScan = true
+	}
+}
+
+// eachShard returns f of every shard, calling it concurrently with WithParallelScan.
+func eachShard[T any](c *Cache, f func(s *Cache) T) []T {
+	results := make([]T, len(c.shards))
+	if !c.parallelScan {
+		for i, s := range c.shards {
+			results[i] = f(s)
+		}
+		return results
+	}
+	var wg sync.WaitGroup
+	for i, s := range c.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = f(s)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// randomKeyOfShards picks a shard with a chance proportional to its size.
+func (c *Cache) randomKeyOfShards() (string, bool) {
+	counts := make([]int, len(c.shards))
+	total := 0
+	for i, s := range c.shards {
+		counts[i] = s.Count()
+		total += counts[i]
+	}
+	if total == 0 {
+		return "", false
+	}
+	r := rand.Intn(total)
+	for i, n := range counts {
+		if r < n {
+			if key, ok := c.shards[i].RandomKey(); ok {
+				return key, true
+			}
+			break
+		}
+		r -= n
+	}
+	// the shard was emptied meanwhile or only holds expired items
+	for _, s := range c.shards {
+		if key, ok := s.RandomKey(); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (c *Cache) countShards() (count int) {
+	for _, s := range c.shards {
+		count += s.Count()
+	}
+	return
+}
+
+func (c *Cache) droppedShardExpirations() (dropped uint64) {
+	for _, s := range c.shards {
+		dropped += s.DroppedExpirations()
+	}
+	return
+}
+
+func (c *Cache) capacityStatsOfShards() CapacityStats {
+	stats := CapacityStats{Evictions: make(map[EvictionReason]uint64, numReasons)}
+	for _, s := range c.shards {
+		ss := s.CapacityStats()
+		stats.Items += ss.Items
+		stats.MaxItems += ss.MaxItems
+		stats.UsedBytes += ss.UsedBytes
+		stats.MaxBytes += ss.MaxBytes
+		stats.Rejected += ss.Rejected
+		stats.Oversized += ss.Oversized
+		for reason, n := range ss.Evictions {
+			stats.Evictions[reason] += n
+		}
+	}
+	return stats
+}
+
+// evictShards evicts from every shard its share of n.
+func (c *Cache) evictShards(n int) (evicted int) {
+	counts := make([]int, len(c.shards))
+	total := 0
+	for i, s := range c.shards {
+		counts[i] = s.Count()
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	for i, s := range c.shards {
+		share := counts[i]
+		if n < total {
+			share = ceilDiv(n*counts[i], total)
+		}
+		evicted += s.EvictN(min(share, n-evicted))
+	}
+	return
+}
+
+func (c *Cache) evictShardsToSize(bytes int64) (evicted int) {
+	for _, s := range c.shards {
+		evicted += s.EvictToSize(bytes / int64(len(c.shards)))
+	}
+	return
+}
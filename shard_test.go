@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shardConfigs are caches that must behave like an unsharded one.
+var shardConfigs = []struct {
+	name string
+	opts []Option
+}{
+	{"shards", []Option{WithShards(4)}},
+	{"one shard", []Option{WithShards(1)}},
+	{"parallel scan", []Option{WithShards(8), WithParallelScan()}},
+	{"sync map", []Option{WithShards(4), WithStorage(StorageSyncMap)}},
+	{"snapshot", []Option{WithShards(4), WithStorage(StorageSnapshot)}},
+}
+
+// transcript runs the same operations on c and records what they return.
+func transcript(c *Cache) []string {
+	var out []string
+	record := func(op string, results ...interface{}) {
+		out = append(out, op+" "+fmt.Sprint(results...))
+	}
+	sorted := func(keys []string) []string {
+		sort.Strings(keys)
+		return keys
+	}
+
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("k", i), i, NoExpiration)
+	}
+	record("count", c.Count())
+	v, found := c.Get("k7")
+	record("get", v, found)
+	record("add existing", c.Add("k7", 0, NoExpiration))
+	record("add missing", c.Add("new", 1, NoExpiration))
+	record("replace missing", c.Replace("none", 1, NoExpiration))
+	n, err := c.Increment("k8", 2)
+	record("increment", n, err)
+	record("rename", c.Rename("k1", "renamed"), c.Exists("k1"), c.Exists("renamed"))
+	record("rename missing", c.Rename("k1", "other"))
+	record("copy", c.Copy("k2", "copied", NoExpiration))
+	old, existed, err := c.Swap("k3", "swapped", NoExpiration)
+	record("swap", old, existed, err)
+	_, version, _ := c.GetWithVersion("k4")
+	swapped, err := c.CompareAndSwap("k4", version, "cas", NoExpiration)
+	record("cas", swapped, err)
+	swapped, err = c.CompareAndSwap("k4", version, "stale", NoExpiration)
+	record("cas stale", swapped, err)
+
+	many := c.GetMany([]string{"k5", "k6", "none"})
+	record("get many", len(many), many["k5"], many["k6"])
+	record("set many", c.SetMany(map[string]interface{}{"m1": 1, "m2": 2}, NoExpiration))
+	record("delete many", c.DeleteMany([]string{"m1", "none"}))
+	record("delete", c.Delete("k9"), c.Delete("k9"))
+
+	record("keys", strings.Join(sorted(c.Keys()), ","))
+	all := c.GetAll()
+	record("get all", len(all), all["renamed"], all["copied"], all["k3"])
+	var visited []string
+	c.ForEach(func(key string, value interface{}) bool {
+		visited = append(visited, key)
+		return true
+	})
+	record("for each", strings.Join(sorted(visited), ","))
+	record("count", c.Count())
+
+	c.Flush()
+	record("flushed", c.Count(), len(c.Keys()), len(c.GetAll()))
+	return out
+}
+
+func TestShardedLikeUnsharded(t *testing.T) {
+	want := transcript(New(0, 0))
+	for _, tc := range shardConfigs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := transcript(New(0, 0, tc.opts...))
+			if len(got) != len(want) {
+				t.Fatalf("got %d results, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("got %q, want %q", got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestShardedExpiration(t *testing.T) {
+	for _, tc := range shardConfigs {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newTestClock()
+			c := New(0, 0, append(tc.opts, WithClock(clock))...)
+			defer c.Close()
+			for i := 0; i < 20; i++ {
+				c.Set(fmt.Sprint("short", i), i, time.Minute)
+				c.Set(fmt.Sprint("long", i), i, time.Hour)
+			}
+			clock.Advance(2 * time.Minute)
+			if _, found := c.Get("short3"); found {
+				t.Error("expired item found")
+			}
+			if n := len(c.Keys()); n != 20 {
+				t.Errorf("%d keys, want 20", n)
+			}
+		})
+	}
+}
+
+func TestShardedMaxItems(t *testing.T) {
+	c := New(0, 0, WithShards(4), WithMaxItems(100))
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprint(i), i, NoExpiration)
+	}
+	// every shard holds at most its part of the limit, rounded up
+	if n := c.Count(); n > 100 || n < 50 {
+		t.Errorf("count = %d, want about 100", n)
+	}
+	if _, found := c.Get("999"); !found {
+		t.Error("last key evicted")
+	}
+}
+
+func TestShardedRenameRefused(t *testing.T) {
+	c := New(0, 0, WithShards(2), WithMaxItems(4), WithPolicy(PolicyTinyLFU))
+	// keys of the shards 0 and 1
+	var keys [2][]string
+	for i := 0; len(keys[0]) < 1 || len(keys[1]) < 3; i++ {
+		key := fmt.Sprint("k", i)
+		s := c.shardIndex(key)
+		keys[s] = append(keys[s], key)
+	}
+	oldKey, hot, newKey := keys[0][0], keys[1][:2], keys[1][2]
+	c.Set(oldKey, "moved", NoExpiration)
+	for _, key := range hot {
+		c.Set(key, 1, NoExpiration)
+		for i := 0; i < 5; i++ {
+			c.Get(key)
+		}
+	}
+
+	if err := c.Rename(oldKey, newKey); err != nil {
+		t.Fatal(err)
+	}
+	if c.Exists(newKey) {
+		t.Fatal("the full shard admitted the rarely used key")
+	}
+	if v, found := c.Get(oldKey); !found || v != "moved" {
+		t.Errorf("refused rename lost the item: %v, %v", v, found)
+	}
+	for _, key := range hot {
+		if !c.Exists(key) {
+			t.Errorf("%s evicted", key)
+		}
+	}
+	if n := c.Count(); n != 3 {
+		t.Errorf("count = %d, want 3", n)
+	}
+}
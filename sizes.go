@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sizeProfileLargest is how many of the largest sampled values SizeProfile lists.
+const sizeProfileLargest = 10
+
+// WithSizeSampling estimates the sizes of keys and values every interval from
+// sampleSize random items, for SizeProfile. Values are measured as for
+// WithMaxMemory, after the lock is released.
+func WithSizeSampling(interval time.Duration, sampleSize int) Option {
+	return func(c *Cache) {
+		c.sizeInterval = interval
+		c.sizeSample = sampleSize
+	}
+}
+
+// SizeProfile is the result of the last size sample.
+type SizeProfile struct {
+	Sampled   int
+	Taken     time.Time
+	KeyLength Percentiles
+	ValueSize Percentiles
+	Largest   []SizedKey // the largest values sampled, largest first
+}
+
+type Percentiles struct {
+	P50, P90, P99, Max int64
+}
+
+type SizedKey struct {
+	Key  string
+	Size int64
+}
+
+type sizeProfiler struct {
+	mu      sync.Mutex
+	profile SizeProfile
+}
+
+// SizeProfile returns the last size sample, zero before the first or
+// unless the cache was created with WithSizeSampling.
+func (c *Cache) SizeProfile() SizeProfile {
+	c.sizes.mu.Lock()
+	defer c.sizes.mu.Unlock()
+	return c.sizes.profile
+}
+
+func (c *Cache) sampleSizesEvery() {
+	for {
+		select {
+		case <-c.clock.After(c.sizeInterval):
+			c.sampleSizes()
+		case <-c.stop:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// sampleSizes measures random items, the same number from every shard.
+func (c *Cache) sampleSizes() {
+	caches := c.shards
+	if caches == nil {
+		caches = []*Cache{c}
+	}
+	perCache := (c.sizeSample + len(caches) - 1) / len(caches)
+	var sample []keyItem
+	for _, s := range caches {
+		s.RLock()
+		for i := 0; i < perCache && len(s.keys) > 0; i++ {
+			k := s.keys[rand.Intn(len(s.keys))]
+			sample = append(sample, keyItem{k, s.items[k]})
+		}
+		s.RUnlock()
+	}
+
+	keys := make([]int64, len(sample))
+	values := make([]SizedKey, len(sample))
+	for i, e := range sample {
+		keys[i] = int64(len(e.key))
+		values[i] = SizedKey{e.key, sizeOf(e.item.Value)}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	sort.Slice(values, func(i, j int) bool { return values[i].Size > values[j].Size })
+	sizes := make([]int64, len(values))
+	for i, v := range values {
+		sizes[len(values)-1-i] = v.Size
+	}
+
+	profile := SizeProfile{
+		Sampled:   len(sample),
+		Taken:     c.clock.Now(),
+		KeyLength: percentilesOf(keys),
+		ValueSize: percentilesOf(sizes),
+		Largest:   values[:min(len(values), sizeProfileLargest)],
+	}
+	c.sizes.mu.Lock()
+	c.sizes.profile = profile
+	c.sizes.mu.Unlock()
+}
+
+// percentilesOf takes sorted values.
+func percentilesOf(sorted []int64) Percentiles {
+	if len(sorted) == 0 {
+		return Percentiles{}
+	}
+	at := func(q float64) int64 {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	return Percentiles{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: sorted[len(sorted)-1]}
+}
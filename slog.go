@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger sends the messages of the cache to logger as structured
+// records: GC cycles with their duration, evictions with the key and
+// reason at debug level, and errors.
+func WithLogger(logger *slog.Logger) Option {
+	return WithLog(slogLogger{logger})
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l.logger.Log(context.Background(), slogLevel(level), msg, keyvals...)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogDebug:
+		return slog.LevelDebug
+	case LogError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
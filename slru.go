@@ -0,0 +1,57 @@
+package main
+
+// slruPolicy is the segmented LRU: new keys start on probation and are
+// promoted to the protected segment when read again. Victims come from
+// probation first, so keys that are never read again go quickly, while
+// the protected segment, bounded to 80% of the capacity, demotes its
+// oldest keys back to probation.
+type slruPolicy struct {
+	probation    *lruPolicy
+	protected    *lruPolicy
+	protectedCap int
+}
+
+func newSLRUPolicy(capacity int) *slruPolicy {
+	return &slruPolicy{
+		probation:    newLRUPolicy(),
+		protected:    newLRUPolicy(),
+		protectedCap: max(1, capacity*8/10),
+	}
+}
+
+func (p *slruPolicy) OnSet(key string) {
+	if _, found := p.probation.elems[key]; found {
+		p.OnGet(key)
+		return
+	}
+	if _, found := p.protected.elems[key]; found {
+		p.protected.OnGet(key)
+		return
+	}
+	p.probation.OnSet(key)
+}
+
+func (p *slruPolicy) OnGet(key string) {
+	if _, found := p.probation.elems[key]; !found {
+		p.protected.OnGet(key)
+		return
+	}
+	p.probation.OnDelete(key)
+	p.protected.OnSet(key)
+	if p.protected.ll.Len() > p.protectedCap {
+		demoted, _ := p.protected.Victim()
+		p.probation.OnSet(demoted)
+	}
+}
+
+func (p *slruPolicy) OnDelete(key string) {
+	p.probation.OnDelete(key)
+	p.protected.OnDelete(key)
+}
+
+func (p *slruPolicy) Victim() (string, bool) {
+	if key, ok := p.probation.Victim(); ok {
+		return key, true
+	}
+	return p.protected.Victim()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WithSnapshotEvery writes Save of the cache to path every interval in the
+// background, to be read back with LoadFile. Each snapshot goes to a temporary
+// file next to path that is renamed over it once complete, so path always
+// holds a whole snapshot.
+func WithSnapshotEvery(interval time.Duration, path string) Option {
+	return func(c *Cache) {
+		c.snapshotInterval = interval
+		c.snapshotPath = path
+	}
+}
+
+// SnapshotInfo describes a snapshot written for WithSnapshotEvery.
+type SnapshotInfo struct {
+	Path     string
+	Size     int64 // bytes written
+	Duration time.Duration
+	Err      error // why the snapshot failed, the previous one is kept
+}
+
+// OnSnapshot registers f to be called after every snapshot of
+// WithSnapshotEvery, failed or not; nil unregisters it.
+func (c *Cache) OnSnapshot(f func(info SnapshotInfo)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onSnapshot = f
+}
+
+func (c *Cache) snapshotEvery() {
+	for {
+		select {
+		case <-c.clock.After(c.snapshotInterval):
+			c.snapshotDone(c.writeSnapshot())
+		case <-c.stop:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writeSnapshot saves the cache to a temporary file and renames it to the
+// snapshot path.
+func (c *Cache) writeSnapshot() SnapshotInfo {
+	start := c.clock.Now()
+	info := SnapshotInfo{Path: c.snapshotPath}
+	f, err := os.CreateTemp(filepath.Dir(c.snapshotPath), filepath.Base(c.snapshotPath)+".*.tmp")
+	if err != nil {
+		info.Err = err
+		return info
+	}
+	bw := bufio.NewWriter(f)
+	w := &countingWriter{w: bw}
+	err = c.Save(w)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && c.isClosed() {
+		// Close may have emptied the cache halfway through
+		err = ErrClosed
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.snapshotPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	info.Size = w.n
+	info.Duration = c.clock.Now().Sub(start)
+	info.Err = err
+	return info
+}
+
+func (c *Cache) isClosed() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.closed
+}
+
+func (c *Cache) snapshotDone(info SnapshotInfo) {
+	if info.Err != nil {
+		c.log(LogError, "snapshot failed", "path", info.Path, "err", info.Err)
+	} else {
+		c.log(LogDebug, "snapshot written", "path", info.Path, "size", info.Size, "duration", info.Duration)
+	}
+	c.RLock()
+	f := c.onSnapshot
+	c.RUnlock()
+	if f != nil {
+		f(info)
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CapacityStats shows how close the cache is to its limits.
+type CapacityStats struct {
+	Items     int
+	MaxItems  int   // 0 if unbounded
+	UsedBytes int64 // estimated, only accounted with WithMaxMemory
+	MaxBytes  int64 // 0 if unbounded
+	Evictions map[EvictionReason]uint64
+	Rejected  uint64 // new keys refused by an admission policy
+	Oversized uint64 // writes refused for being too large
+}
+
+// CapacityStats returns the current usage and the counters since the cache
+// was created. They are read from atomic counters without taking the lock.
+func (c *Cache) CapacityStats() CapacityStats {
+	if c.shards != nil {
+		return c.capacityStatsOfShards()
+	}
+	stats := CapacityStats{
+		Items:     c.Count(),
+		MaxItems:  c.maxItems,
+		UsedBytes: c.usedBytes.Load(),
+		MaxBytes:  c.maxMemory,
+		Evictions: make(map[EvictionReason]uint64, numReasons),
+		Rejected:  c.rejected.Load(),
+		Oversized: c.oversized.Load(),
+	}
+	for reason := range c.evictions {
+		stats.Evictions[EvictionReason(reason)] = c.evictions[reason].Load()
+	}
+	return stats
+}
+
+// Stats counts the operations on the cache since it was created or
+// ResetStats was last called.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Sets        uint64 // values stored, by any write
+	Deletes     uint64 // items removed by Delete and friends
+	Expirations uint64
+	Evictions   uint64 // items removed to make room
+	Taken       time.Time
+	resets      uint64 // ResetStats calls before Taken
+}
+
+// HitRatio is the share of reads that found a value, 0 without reads.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type statCounters struct {
+	hits, misses, sets, deletes, expirations, evictions atomic.Uint64
+	resets                                              atomic.Uint64
+}
+
+// Stats returns the operation counters. They are read without locking,
+// each of them atomically.
+func (c *Cache) Stats() Stats {
+	if c.shards != nil {
+		stats := Stats{Taken: c.clock.Now()}
+		for _, s := range c.shards {
+			ss := s.Stats()
+			stats.Hits += ss.Hits
+			stats.Misses += ss.Misses
+			stats.Sets += ss.Sets
+			stats.Deletes += ss.Deletes
+			stats.Expirations += ss.Expirations
+			stats.Evictions += ss.Evictions
+			stats.resets += ss.resets
+		}
+		return stats
+	}
+	return Stats{
+		Hits:        c.stats.hits.Load(),
+		Misses:      c.stats.misses.Load(),
+		Sets:        c.stats.sets.Load(),
+		Deletes:     c.stats.deletes.Load(),
+		Expirations: c.stats.expirations.Load(),
+		Evictions:   c.stats.evictions.Load(),
+		Taken:       c.clock.Now(),
+		resets:      c.stats.resets.Load(),
+	}
+}
+
+// StatsWindow is what the counters of Stats counted between two of them.
+type StatsWindow struct {
+	Stats
+	Duration time.Duration
+}
+
+// PerSecond turns a count of the window into a rate.
+func (w StatsWindow) PerSecond(n uint64) float64 {
+	if w.Duration <= 0 {
+		return 0
+	}
+	return float64(n) / w.Duration.Seconds()
+}
+
+// StatsSince returns the counts since prev was taken by Stats, for the rates
+// and hit ratio of that window. Counters reset by ResetStats in between
+// count from zero.
+func (c *Cache) StatsSince(prev Stats) StatsWindow {
+	cur := c.Stats()
+	reset := cur.resets != prev.resets
+	since := func(n, before uint64) uint64 {
+		if reset || n < before {
+			return n
+		}
+		return n - before
+	}
+	return StatsWindow{
+		Stats: Stats{
+			Hits:        since(cur.Hits, prev.Hits),
+			Misses:      since(cur.Misses, prev.Misses),
+			Sets:        since(cur.Sets, prev.Sets),
+			Deletes:     since(cur.Deletes, prev.Deletes),
+			Expirations: since(cur.Expirations, prev.Expirations),
+			Evictions:   since(cur.Evictions, prev.Evictions),
+			Taken:       cur.Taken,
+		},
+		Duration: cur.Taken.Sub(prev.Taken),
+	}
+}
+
+// ResetStats sets the operation counters back to zero. CapacityStats
+// keeps counting since the cache was created.
+func (c *Cache) ResetStats() {
+	for _, s := range c.shards {
+		s.ResetStats()
+	}
+	c.stats.hits.Store(0)
+	c.stats.misses.Store(0)
+	c.stats.sets.Store(0)
+	c.stats.deletes.Store(0)
+	c.stats.expirations.Store(0)
+	c.stats.evictions.Store(0)
+	c.stats.resets.Add(1)
+}
+
+// hit counts a read of key, returning found so callers can count as they return.
+func (c *Cache) hit(key string, found bool) bool {
+	c.namespaceCountersOf(key).read(found)
+	if found {
+		c.stats.hits.Add(1)
+	} else {
+		c.stats.misses.Add(1)
+	}
+	return found
+}
@@ -0,0 +1,120 @@
+package main
+
+// StorageMode selects how reads find items. Writes always go through the
+// map guarded by the cache lock.
+type StorageMode int
+
+const (
+	StorageMap      StorageMode = iota // reads take the read lock
+	StorageSyncMap                     // reads go through a sync.Map copy, without locking
+	StorageSnapshot                    // reads go through an immutable copy, without locking
+)
+
+// WithStorage sets the storage mode. StorageSyncMap suits caches that are
+// read far more often than written: every write also updates the copy.
+// StorageSnapshot is for caches hardly ever written: every operation that
+// writes copies the whole map, which readers then switch to atomically.
+func WithStorage(mode StorageMode) Option {
+	return func(c *Cache) {
+		c.storage = mode
+	}
+}
+
+// lookup returns the item as the read paths see it.
+func (c *Cache) lookup(key string) (Item, bool) {
+	switch c.storage {
+	case StorageSyncMap:
+		if v, ok := c.readMap.Load(key); ok {
+			return *v.(*Item), true
+		}
+		return Item{}, false
+	case StorageSnapshot:
+		if m := c.snapshot.Load(); m != nil {
+			item, found := (*m)[key]
+			return item, found
+		}
+		return Item{}, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	item, found := c.items[key]
+	return item, found
+}
+
+// entries returns the unexpired items and the keys of the expired ones, as of
+// one point in time unless the storage is StorageSyncMap.
+func (c *Cache) entries() (entries []keyItem, expired []string) {
+	add := func(k string, item Item, now int64) {
+		if item.expired(now) {
+			expired = append(expired, k)
+		} else {
+			entries = append(entries, keyItem{k, item})
+		}
+	}
+	switch c.storage {
+	case StorageSyncMap:
+		now := c.now()
+		c.readMap.Range(func(k, v interface{}) bool {
+			add(k.(string), *v.(*Item), now)
+			return true
+		})
+		return
+	case StorageSnapshot:
+		if m := c.snapshot.Load(); m != nil {
+			now := c.now()
+			entries = make([]keyItem, 0, len(*m))
+			for k, item := range *m {
+				add(k, item, now)
+			}
+		}
+		return
+	}
+	c.RLock()
+	defer c.RUnlock()
+	now := c.now()
+	entries = make([]keyItem, 0, len(c.items))
+	for k, item := range c.items {
+		add(k, item, now)
+	}
+	return
+}
+
+// mirror brings the copy read by lookup in line with c.items[key].
+// The caller holds the write lock.
+func (c *Cache) mirror(key string) {
+	if c.storage == StorageSnapshot {
+		c.snapshotDirty = true
+	}
+	if c.storage != StorageSyncMap {
+		return
+	}
+	if item, found := c.items[key]; found {
+		c.readMap.Store(key, &item)
+	} else {
+		c.readMap.Delete(key)
+	}
+}
+
+// mirrorClear empties the copy read by lookup. The caller holds the write lock.
+func (c *Cache) mirrorClear() {
+	switch c.storage {
+	case StorageSyncMap:
+		c.readMap.Clear()
+	case StorageSnapshot:
+		c.snapshotDirty = true
+	}
+}
+
+// publish replaces the snapshot after writes. Unlock calls it while the
+// write lock is still held.
+func (c *Cache) publish() {
+	if !c.snapshotDirty {
+		return
+	}
+	c.snapshotDirty = false
+	m := make(map[string]Item, len(c.items))
+	for k, item := range c.items {
+		m[k] = item
+	}
+	c.snapshot.Store(&m)
+}
@@ -0,0 +1,97 @@
+package main
+
+import "hash/maphash"
+
+// tinyLFUPolicy is LRU behind a TinyLFU admission filter: every access,
+// including misses, is counted in a frequency sketch, and a new key is only
+// let into a full cache if it is used at least as often as the LRU victim.
+// A scan over many one-off keys therefore can not flush the hot set.
+type tinyLFUPolicy struct {
+	*lruPolicy
+	sketch *cmSketch
+}
+
+func newTinyLFUPolicy(capacity int) *tinyLFUPolicy {
+	return &tinyLFUPolicy{lruPolicy: newLRUPolicy(), sketch: newCMSketch(capacity)}
+}
+
+func (p *tinyLFUPolicy) OnSet(key string) {
+	p.sketch.increment(key)
+	p.lruPolicy.OnSet(key)
+}
+
+func (p *tinyLFUPolicy) OnGet(key string) {
+	p.sketch.increment(key)
+	p.lruPolicy.OnGet(key)
+}
+
+func (p *tinyLFUPolicy) Admit(key string) bool {
+	victim, ok := p.oldest()
+	if !ok || p.sketch.estimate(key)+1 >= p.sketch.estimate(victim) {
+		return true
+	}
+	p.sketch.increment(key)
+	return false
+}
+
+// cmSketch is a count-min sketch of 4 rows with counters saturating at 15.
+// All counters are halved after ten times as many increments as a row has
+// counters, so old popularity fades away.
+type cmSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	seed      maphash.Seed
+	additions int
+	resetAt   int
+}
+
+func newCMSketch(capacity int) *cmSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	s := &cmSketch{mask: uint64(width - 1), seed: maphash.MakeSeed(), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *cmSketch) indexes(key string) [4]uint64 {
+	h := maphash.String(s.seed, key)
+	h1, h2 := h, h>>32|h<<32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *cmSketch) increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < 15 {
+			s.rows[i][j]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *cmSketch) estimate(key string) uint8 {
+	est := uint8(15)
+	for i, j := range s.indexes(key) {
+		est = min(est, s.rows[i][j])
+	}
+	return est
+}
+
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,63 @@
+package main
+
+import "time"
+
+// ttlBuckets are the upper bounds of the remaining TTL histogram.
+var ttlBuckets = [...]time.Duration{
+	time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// TTLDistribution returns a histogram of the remaining lifetime of the items
+// that expire, and the number of items that do not. Items already expired
+// but not yet deleted count as having no time left.
+func (c *Cache) TTLDistribution() (h Histogram, persistent int) {
+	h = Histogram{Bounds: ttlBuckets[:], Counts: make([]uint64, len(ttlBuckets)+1)}
+	caches := c.shards
+	if caches == nil {
+		caches = []*Cache{c}
+	}
+	for _, s := range caches {
+		s.RLock()
+		now := s.now()
+		for _, e := range s.expiries {
+			left := time.Duration(max(e.at-now, 0))
+			i := 0
+			for i < len(ttlBuckets) && left > ttlBuckets[i] {
+				i++
+			}
+			h.Counts[i]++
+			h.Sum += left
+		}
+		h.Count += uint64(len(s.expiries))
+		persistent += len(s.items) - len(s.expiries)
+		s.RUnlock()
+	}
+	return h, persistent
+}
+
+// ExpiryForecast returns how many of the items stored now expire in each
+// of the next n intervals, to anticipate bursts of expirations and the
+// reloads following them.
+func (c *Cache) ExpiryForecast(interval time.Duration, n int) []int {
+	forecast := make([]int, n)
+	if interval <= 0 {
+		return forecast
+	}
+	caches := c.shards
+	if caches == nil {
+		caches = []*Cache{c}
+	}
+	for _, s := range caches {
+		s.RLock()
+		now := s.now()
+		for _, e := range s.expiries {
+			if i := (e.at - now) / int64(interval); e.at >= now && i < int64(n) {
+				forecast[i]++
+			}
+		}
+		s.RUnlock()
+	}
+	return forecast
+}